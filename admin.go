@@ -0,0 +1,67 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+
+	"github.com/Scalingo/go-handlers"
+	"github.com/Scalingo/go-utils/logger"
+	"github.com/dvaumoron/sclng-backend-test-v1/repositoryservice"
+)
+
+const (
+	bearerPrefix = "Bearer "
+
+	invalidAuthMsg   = "invalid admin token"
+	refreshCancelMsg = "request ended before the refresh"
+	discardedMsg     = "refresh deadline exceeded, its partial data are discarded"
+)
+
+func makeAdminAuth(adminToken string, next handlers.HandlerFunc) handlers.HandlerFunc {
+	expected := []byte(bearerPrefix + adminToken)
+	return func(w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+		// constant time comparison to avoid leaking the token through response delay
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+			writeJson(r, w, http.StatusUnauthorized, map[string]string{"error": invalidAuthMsg})
+			return nil
+		}
+		return next(w, r, vars)
+	}
+}
+
+// POST /admin/refresh?wait=true block until the new snapshot is published
+func makeRefreshHandler(repoService repositoryservice.RepositoryService) handlers.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, _ map[string]string) error {
+		ticket := repoService.Refresh()
+		logger.Get(r.Context()).WithField("refresh_id", ticket.Id).WithField("coalesced", ticket.Coalesced).Info("Refresh triggered")
+
+		result := map[string]any{
+			"refresh_id": ticket.Id, "coalesced": ticket.Coalesced, "pool": poolStatsJson(repoService.PoolStats()),
+		}
+		if wait, _ := strconv.ParseBool(r.URL.Query().Get("wait")); !wait {
+			writeJson(r, w, http.StatusAccepted, result)
+			return nil
+		}
+
+		select {
+		case <-ticket.Done:
+		case <-r.Context().Done():
+			result["error"] = refreshCancelMsg
+			writeJson(r, w, http.StatusGatewayTimeout, result)
+			return nil
+		}
+
+		if ticket.Discarded() {
+			result["discarded"] = true
+			result["error"] = discardedMsg
+		}
+		// the published snapshot can be a later one when refreshes are chained
+		snapshot, _ := repoService.Peek() // ready once a refresh is done
+		result["published_refresh_id"] = snapshot.RefreshId
+		result["fetched_at"] = snapshot.FetchedAt
+		result["refresh_duration"] = snapshot.RefreshDuration.String()
+		writeJson(r, w, http.StatusOK, result)
+		return nil
+	}
+}
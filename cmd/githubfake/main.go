@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/Scalingo/go-utils/logger"
+	"github.com/dvaumoron/sclng-backend-test-v1/githubfake"
+	"github.com/kelseyhightower/envconfig"
+)
+
+// run the fake GitHub API, use it with GITHUB_API_URL and GITHUB_EVENT_API_URL pointing to it and AUTH_MODE=none
+type Config struct {
+	Port            int           `envconfig:"PORT" default:"5001"`
+	RepositoryCount int           `envconfig:"FAKE_REPOSITORY_COUNT" default:"150"`
+	EventCount      int           `envconfig:"FAKE_EVENT_COUNT" default:"300"`
+	RateLimit       int           `envconfig:"FAKE_RATE_LIMIT" default:"5000"`
+	Delay           time.Duration `envconfig:"FAKE_DELAY"`
+	FixtureDir      string        `envconfig:"FAKE_FIXTURE_DIR"` // recorded with HTTP_RECORD_DIR
+	ErrorPath       string        `envconfig:"FAKE_ERROR_PATH"`  // path prefix of failing calls
+	ErrorStatus     int           `envconfig:"FAKE_ERROR_STATUS" default:"500"`
+	ErrorEvery      int           `envconfig:"FAKE_ERROR_EVERY" default:"1"`
+}
+
+func main() {
+	log := logger.Default()
+	var cfg Config
+	if err := envconfig.Process("", &cfg); err != nil {
+		log.WithError(err).Error("Fail to build config from env")
+		os.Exit(1)
+	}
+
+	options := githubfake.Options{
+		RepositoryCount: cfg.RepositoryCount, EventCount: cfg.EventCount, RateLimit: cfg.RateLimit, Delay: cfg.Delay,
+	}
+	if cfg.FixtureDir != "" {
+		fixtures, err := githubfake.LoadFixtures(cfg.FixtureDir)
+		if err != nil {
+			log.WithError(err).Error("Fail to load fixtures")
+			os.Exit(1)
+		}
+		options.Fixtures = fixtures
+		log.WithField("count", len(fixtures)).Info("Fixtures loaded")
+	}
+	if cfg.ErrorPath != "" {
+		options.Errors = []githubfake.ErrorRule{{PathPrefix: cfg.ErrorPath, Status: cfg.ErrorStatus, Every: cfg.ErrorEvery}}
+	}
+
+	log = log.WithField("port", cfg.Port)
+	log.Info("Fake GitHub API listening...")
+	if err := http.ListenAndServe(fmt.Sprintf(":%d", cfg.Port), githubfake.New(options)); err != nil {
+		log.WithError(err).Error("Fail to listen to the given port")
+		os.Exit(2)
+	}
+}
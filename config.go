@@ -1,19 +1,62 @@
 package main
 
 import (
+	"os"
 	"time"
 
+	"github.com/dvaumoron/sclng-backend-test-v1/repositoryservice"
 	"github.com/kelseyhightower/envconfig"
 	"github.com/pkg/errors"
 )
 
+const (
+	unauthenticatedPageSize    = 30
+	unauthenticatedMaxPage     = 1
+	unauthenticatedTargetCount = 10
+)
+
 type Config struct {
-	Port          int           `envconfig:"PORT" default:"5000"`
-	EventApiUrl   string        `envconfig:"GITHUB_EVENT_API_URL" default:"https://api.github.com/events"`
-	EventPageSize int           `envconfig:"GITHUB_EVENT_API_PAGE_SIZE" default:"100"` // 100 item per page is the max allowed by the API
-	Refresh       time.Duration `envconfig:"REFRESH" default:"5m"`
-	MaxCall       int           `envconfig:"MAX_CALL" default:"90"`               // github API accept 100 concurrent requests
-	AccessToken   string        `envconfig:"GITHUB_ACCESS_TOKEN" required:"true"` // without it the API limit is 60 requests per hour
+	Port                 int                      `envconfig:"PORT" default:"5000"`
+	Forge                string                   `envconfig:"FORGE" default:"github"`                                       // github, gitlab or gitea
+	ApiUrl               string                   `envconfig:"GITHUB_API_URL" default:"https://api.github.com"`              // base url of the forge API (like "https://gitlab.com/api/v4")
+	EventApiUrls         []string                 `envconfig:"GITHUB_EVENT_API_URL" default:"https://api.github.com/events"` // each one is "url" or "name=url"
+	EventApiQuotas       []int                    `envconfig:"GITHUB_EVENT_API_QUOTA"`                                       // by position in GITHUB_EVENT_API_URL, default to TARGET_COUNT
+	EventTypes           []string                 `envconfig:"GITHUB_EVENT_TYPES"`                                           // all types when empty
+	ExcludedEventTypes   []string                 `envconfig:"GITHUB_EVENT_TYPES_EXCLUDE"`
+	EventPageSize        int                      `envconfig:"GITHUB_EVENT_API_PAGE_SIZE" default:"100"` // 100 item per page is the max allowed by the API
+	EventMaxPage         int                      `envconfig:"GITHUB_EVENT_API_MAX_PAGE" default:"10"`   // the API return at most 300 events
+	TargetCount          int                      `envconfig:"TARGET_COUNT" default:"100"`               // by source
+	Backend              string                   `envconfig:"GITHUB_FETCH_BACKEND" default:"rest"`      // rest or graphql
+	GraphqlUrl           string                   `envconfig:"GITHUB_GRAPHQL_URL" default:"https://api.github.com/graphql"`
+	GraphqlBatch         int                      `envconfig:"GITHUB_GRAPHQL_BATCH_SIZE" default:"50"` // bigger queries can hit the GraphQL node limit or timeout
+	Enrichers            []string                 `envconfig:"ENRICHERS"`                              // release, contributors, readme, issues or commit_activity
+	EnricherTtls         map[string]time.Duration `envconfig:"ENRICHER_TTL"`                           // like "release:1h,issues:30m"
+	EnricherMinRemaining int                      `envconfig:"ENRICHER_MIN_REMAINING" default:"500"`
+	Refresh              time.Duration            `envconfig:"REFRESH" default:"5m"`
+	RefreshMode          string                   `envconfig:"REFRESH_MODE" default:"fixed"`   // fixed, lazy or adaptive
+	RefreshTtl           time.Duration            `envconfig:"REFRESH_TTL" default:"5m"`       // used by lazy mode
+	RefreshIdle          time.Duration            `envconfig:"REFRESH_IDLE" default:"15m"`     // used by adaptive mode
+	RefreshMax           time.Duration            `envconfig:"REFRESH_MAX" default:"1h"`       // used by adaptive mode
+	RefreshOverlap       string                   `envconfig:"REFRESH_OVERLAP" default:"skip"` // skip or queue
+	RefreshDeadline      time.Duration            `envconfig:"REFRESH_DEADLINE" default:"4m"`
+	MaxCall              int                      `envconfig:"MAX_CALL" default:"90"`     // github API accept 100 concurrent requests
+	AuthMode             string                   `envconfig:"AUTH_MODE" default:"token"` // none, token or app
+	AccessTokens         []string                 `envconfig:"GITHUB_ACCESS_TOKEN"`       // comma separated, the API limit is 5000 requests per hour by token
+	AppId                string                   `envconfig:"GITHUB_APP_ID"`
+	AppInstallationId    string                   `envconfig:"GITHUB_APP_INSTALLATION_ID"`
+	AppPrivateKey        string                   `envconfig:"GITHUB_APP_PRIVATE_KEY"`      // PEM content
+	AppPrivateKeyFile    string                   `envconfig:"GITHUB_APP_PRIVATE_KEY_FILE"` // used when GITHUB_APP_PRIVATE_KEY is empty
+	CallRate             float64                  `envconfig:"CALL_RATE" default:"15"`      // by second, GitHub secondary rate limit is 900 points by minute
+	CallBurst            int                      `envconfig:"CALL_BURST" default:"90"`
+	ThrottleRemaining    int                      `envconfig:"THROTTLE_REMAINING" default:"1000"`
+	HttpTimeout          time.Duration            `envconfig:"HTTP_TIMEOUT" default:"30s"` // by api call
+	HttpProxyUrl         string                   `envconfig:"HTTP_PROXY_URL"`             // proxy from environment when empty
+	HttpCaFile           string                   `envconfig:"HTTP_CA_FILE"`
+	UserAgent            string                   `envconfig:"HTTP_USER_AGENT" default:"sclng-backend-test-v1"` // GitHub refuse requests without User-Agent
+	HttpRecordDir        string                   `envconfig:"HTTP_RECORD_DIR"`                                 // api responses are saved as fixtures in this directory when not empty
+	AdminToken           string                   `envconfig:"ADMIN_TOKEN"`                                     // admin routes are disabled when empty
+	FiltersFile          string                   `envconfig:"FILTERS_FILE" default:"filters.json"`             // saved filters are only kept in memory when empty
+	FiltersMax           int                      `envconfig:"FILTERS_MAX" default:"100"`
 }
 
 func newConfig() (*Config, error) {
@@ -22,5 +65,62 @@ func newConfig() (*Config, error) {
 	if err != nil {
 		return nil, errors.Wrapf(err, "fail to build config from env")
 	}
+	if cfg.RefreshOverlap != repositoryservice.SkipOverlap && cfg.RefreshOverlap != repositoryservice.QueueOverlap {
+		return nil, errors.Errorf("unknown refresh overlap policy %q", cfg.RefreshOverlap)
+	}
+	if err = repositoryservice.ValidateForge(cfg.Forge); err != nil {
+		return nil, err
+	}
+	if cfg.Forge != repositoryservice.GithubForge && (cfg.Backend != repositoryservice.RestBackend || len(cfg.Enrichers) != 0) {
+		return nil, errors.Errorf("graphql backend and enrichers are only available with the %s forge", repositoryservice.GithubForge)
+	}
+	if cfg.Backend != repositoryservice.RestBackend && cfg.Backend != repositoryservice.GraphqlBackend {
+		return nil, errors.Errorf("unknown fetch backend %q", cfg.Backend)
+	}
+	if cfg.AuthMode == repositoryservice.NoAuth && cfg.Backend == repositoryservice.GraphqlBackend {
+		return nil, errors.New("graphql backend need authentication, GitHub refuse unauthenticated GraphQL calls")
+	}
+	if cfg.AuthMode == repositoryservice.AppAuth && cfg.Forge != repositoryservice.GithubForge {
+		return nil, errors.Errorf("app authentication is only available with the %s forge", repositoryservice.GithubForge)
+	}
+	if cfg.HttpRecordDir != "" {
+		// checked once rather than failing to save each response
+		info, err := os.Stat(cfg.HttpRecordDir)
+		if err != nil {
+			return nil, errors.Wrap(err, "fail to access record directory")
+		}
+		if !info.IsDir() {
+			return nil, errors.Errorf("record directory %q is not a directory", cfg.HttpRecordDir)
+		}
+	}
 	return &cfg, nil
 }
+
+func (cfg *Config) makeAuthentication() (repositoryservice.Authentication, error) {
+	privateKey := []byte(cfg.AppPrivateKey)
+	if cfg.AuthMode == repositoryservice.AppAuth && len(privateKey) == 0 && cfg.AppPrivateKeyFile != "" {
+		var err error
+		if privateKey, err = os.ReadFile(cfg.AppPrivateKeyFile); err != nil {
+			return repositoryservice.Authentication{}, errors.Wrap(err, "fail to read app private key file")
+		}
+	}
+	return repositoryservice.MakeAuthentication(cfg.AuthMode, cfg.AccessTokens, cfg.AppId, cfg.AppInstallationId, privateKey)
+}
+
+// the unauthenticated API limit is 60 requests per hour
+func (cfg *Config) scaleDownUnauthenticated() {
+	if cfg.EventPageSize > unauthenticatedPageSize {
+		cfg.EventPageSize = unauthenticatedPageSize
+	}
+	if cfg.EventMaxPage > unauthenticatedMaxPage {
+		cfg.EventMaxPage = unauthenticatedMaxPage
+	}
+	if cfg.TargetCount > unauthenticatedTargetCount {
+		cfg.TargetCount = unauthenticatedTargetCount
+	}
+	for index, quota := range cfg.EventApiQuotas {
+		if quota > unauthenticatedTargetCount {
+			cfg.EventApiQuotas[index] = unauthenticatedTargetCount
+		}
+	}
+}
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/Scalingo/go-handlers"
+	"github.com/Scalingo/go-utils/logger"
+	"github.com/dvaumoron/sclng-backend-test-v1/filterstore"
+	"github.com/dvaumoron/sclng-backend-test-v1/predicate"
+	"github.com/dvaumoron/sclng-backend-test-v1/repositoryservice"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	andMode = "and"
+	orMode  = "or"
+
+	maxFilterBodySize = 64 << 10 // bytes
+
+	defaultExplainSample = 5
+	maxExplainSample     = 50
+
+	unknownFilterModeMsg = "unknown filter mode, and is used"
+	missingExpressionMsg = "expression parameter is required"
+	invalidSampleMsg     = "sample must be an integer between 1 and 50"
+	unknownFilterMsg     = "unknown saved filter"
+	invalidBodyMsg       = "body must be a JSON object with an expression string (at most 64 KiB)"
+	saveFilterErrorMsg   = "can not save filter"
+)
+
+type filterError struct {
+	Parameter  string `json:"parameter"`
+	Index      int    `json:"index"` // position of the expression among the values of the parameter
+	Expression string `json:"expression"`
+	Error      string `json:"error"`
+	Details    string `json:"details,omitempty"`
+}
+
+// saved, simple query (see predicate.TranslateQuery), filter (combined following filter_mode) and exclude parameters, the used parameters are added to result,
+// return a nil predicate when there is nothing to filter
+func parseRepoFilters(log logrus.FieldLogger, query url.Values, filterStore *filterstore.Store, result map[string]any) (func(any) bool, []filterError) {
+	var predicates []func(any) bool // all must match
+	var filterErrors []filterError
+	if saved := query.Get("saved"); saved != "" {
+		result["saved"] = saved
+		// precompiled when the filter was saved
+		if _, savedPredicate, ok := filterStore.Get(saved); ok {
+			predicates = append(predicates, savedPredicate)
+		} else {
+			filterErrors = append(filterErrors, filterError{Parameter: "saved", Expression: saved, Error: unknownFilterMsg})
+		}
+	}
+
+	if translated, err := predicate.TranslateQuery(query); err != nil {
+		var queryErr predicate.QueryError
+		errors.As(err, &queryErr)
+		filterErrors = append(filterErrors, filterError{Parameter: queryErr.Parameter, Expression: queryErr.Value, Error: err.Error()})
+	} else if translated != "" {
+		result["query_expression"] = translated // allows to learn the expression language
+		if queryPredicate, err := predicate.ParsePredicate(translated); err == nil {
+			predicates = append(predicates, queryPredicate)
+		} else {
+			// not expected, values are quoted
+			log.WithError(err).WithField("expression", translated).Error(parseFilterErrorMsg)
+			filterErrors = append(filterErrors, filterError{Parameter: "query", Expression: translated, Error: parseFilterErrorMsg, Details: err.Error()})
+		}
+	}
+
+	if filters := query["filter"]; len(filters) != 0 {
+		result["filter"] = filters
+		filterMode := query.Get("filter_mode")
+		combine := allPredicate
+		switch filterMode {
+		case "", andMode:
+			filterMode = andMode
+		case orMode:
+			combine = anyPredicate
+		default:
+			filterErrors = append(filterErrors, filterError{Parameter: "filter_mode", Expression: filterMode, Error: unknownFilterModeMsg})
+			filterMode = andMode
+		}
+		result["filter_mode"] = filterMode
+
+		filterPredicates, errs := compileFilters(log, "filter", filters)
+		filterErrors = append(filterErrors, errs...)
+		if filterPredicate := combine(filterPredicates); filterPredicate != nil {
+			predicates = append(predicates, filterPredicate)
+		}
+	}
+
+	if excludes := query["exclude"]; len(excludes) != 0 {
+		result["exclude"] = excludes
+		excludePredicates, errs := compileFilters(log, "exclude", excludes)
+		filterErrors = append(filterErrors, errs...)
+		if excludePredicate := anyPredicate(excludePredicates); excludePredicate != nil {
+			predicates = append(predicates, notPredicate(excludePredicate))
+		}
+	}
+	return allPredicate(predicates), filterErrors
+}
+
+// compile each expression once, the invalid ones are reported and ignored
+func compileFilters(log logrus.FieldLogger, parameter string, expressions []string) ([]func(any) bool, []filterError) {
+	predicates := make([]func(any) bool, 0, len(expressions))
+	var filterErrors []filterError
+	for index, expression := range expressions {
+		compiled, err := predicate.ParsePredicate(expression)
+		if err != nil {
+			log.WithError(err).WithField("parameter", parameter).WithField("index", index).Error(parseFilterErrorMsg)
+			filterErrors = append(filterErrors, filterError{
+				Parameter: parameter, Index: index, Expression: expression, Error: parseFilterErrorMsg, Details: err.Error(),
+			})
+			continue
+		}
+		predicates = append(predicates, compiled)
+	}
+	return predicates, filterErrors
+}
+
+// nil (no filtering) when there is no predicate
+func allPredicate(predicates []func(any) bool) func(any) bool {
+	switch len(predicates) {
+	case 0:
+		return nil
+	case 1:
+		return predicates[0]
+	}
+	return func(value any) bool {
+		for _, p := range predicates {
+			if !p(value) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// nil (no filtering) when there is no predicate
+func anyPredicate(predicates []func(any) bool) func(any) bool {
+	switch len(predicates) {
+	case 0:
+		return nil
+	case 1:
+		return predicates[0]
+	}
+	return func(value any) bool {
+		for _, p := range predicates {
+			if p(value) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+func notPredicate(p func(any) bool) func(any) bool {
+	return func(value any) bool {
+		return !p(value)
+	}
+}
+
+// GET /filters/validate?expression=..., the fields are checked against the current repositories (when retrieved)
+func makeValidateFilterHandler(repoService repositoryservice.RepositoryService) handlers.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, _ map[string]string) error {
+		expression := r.URL.Query().Get("expression")
+		if expression == "" {
+			writeJson(r, w, http.StatusBadRequest, map[string]string{"error": missingExpressionMsg})
+			return nil
+		}
+
+		var schema map[string]any
+		snapshot, ready := repoService.Peek()
+		if ready {
+			schema = snapshot.Schema()
+		}
+		validation := predicate.Validate(expression, schema)
+		result := map[string]any{
+			"expression": expression, "valid": len(validation.Errors) == 0, "errors": issuesJson(validation.Errors),
+			"warnings": issuesJson(validation.Warnings), "schema_checked": ready,
+		}
+		if ready {
+			result["result_type"] = validation.ResultType
+			result["unknown_fields"] = stringsJson(validation.UnknownFields)
+		}
+		writeJson(r, w, http.StatusOK, result)
+		return nil
+	}
+}
+
+// GET /filters/explain?expression=...&sample=5, evaluate each sub-expression on the first repositories
+func makeExplainFilterHandler(repoService repositoryservice.RepositoryService) handlers.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, _ map[string]string) error {
+		query := r.URL.Query()
+		expression := query.Get("expression")
+		if expression == "" {
+			writeJson(r, w, http.StatusBadRequest, map[string]string{"error": missingExpressionMsg})
+			return nil
+		}
+		sample := defaultExplainSample
+		if sampleParam := query.Get("sample"); sampleParam != "" {
+			var err error
+			if sample, err = strconv.Atoi(sampleParam); err != nil || sample < 1 || sample > maxExplainSample {
+				writeJson(r, w, http.StatusBadRequest, map[string]string{"error": invalidSampleMsg})
+				return nil
+			}
+		}
+
+		explainer, err := predicate.NewExplainer(expression)
+		if err != nil {
+			writeJson(r, w, http.StatusBadRequest, map[string]string{"expression": expression, "error": parseFilterErrorMsg, "details": err.Error()})
+			return nil
+		}
+		snapshot, ready := repoService.Peek()
+		if !ready {
+			writeJson(r, w, http.StatusServiceUnavailable, map[string]any{"status": notReadyMsg, "refreshing": snapshot.Refreshing})
+			return nil
+		}
+
+		repositories := snapshot.Sample(sample)
+		explained := make([]map[string]any, 0, len(repositories))
+		for _, repository := range repositories {
+			match, evaluations := explainer.Explain(repository)
+			evaluationsJson := make([]map[string]any, 0, len(evaluations))
+			for _, evaluation := range evaluations {
+				evaluationJson := map[string]any{"expression": evaluation.Expression, "value": evaluation.Value}
+				if evaluation.Err != nil {
+					evaluationJson["error"] = evaluation.Err.Error()
+				}
+				evaluationsJson = append(evaluationsJson, evaluationJson)
+			}
+			explained = append(explained, map[string]any{
+				"full_name": repository["full_name"], "match": match, "evaluations": evaluationsJson,
+			})
+		}
+		writeJson(r, w, http.StatusOK, map[string]any{"expression": expression, "repositories": explained})
+		return nil
+	}
+}
+
+func issuesJson(issues []predicate.Issue) []map[string]any {
+	issuesJson := make([]map[string]any, 0, len(issues))
+	for _, issue := range issues {
+		issueJson := map[string]any{"message": issue.Message}
+		if issue.Line != 0 {
+			issueJson["line"], issueJson["column"] = issue.Line, issue.Column
+		}
+		issuesJson = append(issuesJson, issueJson)
+	}
+	return issuesJson
+}
+
+// encoded as an empty array rather than null
+func stringsJson(values []string) []string {
+	if values == nil {
+		return []string{}
+	}
+	return values
+}
+
+// GET /filters
+func makeListFiltersHandler(store *filterstore.Store) handlers.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, _ map[string]string) error {
+		writeJson(r, w, http.StatusOK, map[string]any{"filters": store.List()})
+		return nil
+	}
+}
+
+// GET /filters/{name}
+func makeGetFilterHandler(store *filterstore.Store) handlers.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+		name := vars["name"]
+		expression, _, ok := store.Get(name)
+		if !ok {
+			writeJson(r, w, http.StatusNotFound, map[string]string{"name": name, "error": unknownFilterMsg})
+			return nil
+		}
+		writeJson(r, w, http.StatusOK, map[string]string{"name": name, "expression": expression})
+		return nil
+	}
+}
+
+// PUT /filters/{name} with a body like {"expression": "language == \"Go\""}
+func makePutFilterHandler(store *filterstore.Store) handlers.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+		name := vars["name"]
+		var body struct {
+			Expression *string `json:"expression"`
+		}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxFilterBodySize)).Decode(&body); err != nil || body.Expression == nil {
+			writeJson(r, w, http.StatusBadRequest, map[string]string{"name": name, "error": invalidBodyMsg})
+			return nil
+		}
+
+		created, err := store.Put(name, *body.Expression)
+		if err != nil {
+			var persistErr filterstore.PersistError
+			if err == filterstore.ErrTooMany {
+				writeJson(r, w, http.StatusConflict, map[string]string{"name": name, "error": err.Error()})
+			} else if err == filterstore.ErrInvalidName || err == filterstore.ErrReservedName {
+				writeJson(r, w, http.StatusBadRequest, map[string]string{"name": name, "error": err.Error()})
+			} else if errors.As(err, &persistErr) {
+				logger.Get(r.Context()).WithField("name", name).WithError(err).Error("Fail to persist saved filters")
+				writeJson(r, w, http.StatusInternalServerError, map[string]string{"name": name, "error": saveFilterErrorMsg})
+			} else {
+				// compilation errors are meant for the caller
+				writeJson(r, w, http.StatusBadRequest, map[string]string{"name": name, "error": parseFilterErrorMsg, "details": err.Error()})
+			}
+			return nil
+		}
+
+		status := http.StatusOK
+		if created {
+			status = http.StatusCreated
+		}
+		writeJson(r, w, status, map[string]string{"name": name, "expression": *body.Expression})
+		return nil
+	}
+}
+
+// DELETE /filters/{name}
+func makeDeleteFilterHandler(store *filterstore.Store) handlers.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+		name := vars["name"]
+		deleted, err := store.Delete(name)
+		if err != nil {
+			logger.Get(r.Context()).WithField("name", name).WithError(err).Error("Fail to persist saved filters")
+			writeJson(r, w, http.StatusInternalServerError, map[string]string{"name": name, "error": saveFilterErrorMsg})
+			return nil
+		}
+		if !deleted {
+			writeJson(r, w, http.StatusNotFound, map[string]string{"name": name, "error": unknownFilterMsg})
+			return nil
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+}
@@ -0,0 +1,197 @@
+package filterstore
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+
+	"github.com/dvaumoron/sclng-backend-test-v1/predicate"
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrInvalidName  = errors.New("filter name must only contain letters, digits, '-' and '_'")
+	ErrReservedName = errors.New("filter name is reserved")
+	ErrTooMany      = errors.New("maximum number of saved filters reached")
+
+	validName = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+	// used by other routes under /filters
+	reservedNames = map[string]struct{}{"validate": {}, "explain": {}}
+)
+
+// failure to write the filters file, the store is left unchanged
+type PersistError struct {
+	err error
+}
+
+// named filter expressions, persisted as a JSON object in a local file,
+// their predicates are compiled once (when loaded or saved)
+type Store struct {
+	path     string // no persistence when empty
+	maxCount int    // new filters are refused when it is reached
+	mutex    sync.RWMutex
+	filters  map[string]savedFilter
+}
+
+type savedFilter struct {
+	expression string
+	predicate  func(any) bool
+}
+
+// a missing file is an empty store
+func Load(path string, maxCount int) (*Store, error) {
+	s := &Store{path: path, maxCount: maxCount, filters: map[string]savedFilter{}}
+	if path == "" {
+		return s, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "fail to read saved filters")
+	}
+
+	var expressions map[string]string
+	if err = json.Unmarshal(data, &expressions); err != nil {
+		return nil, errors.Wrap(err, "fail to parse saved filters")
+	}
+	for name, expression := range expressions {
+		if err = checkName(name); err != nil {
+			return nil, errors.Wrapf(err, "invalid saved filter %q", name)
+		}
+		compiled, err := predicate.ParsePredicate(expression)
+		if err != nil {
+			return nil, errors.Wrapf(err, "fail to compile saved filter %q", name)
+		}
+		s.filters[name] = savedFilter{expression: expression, predicate: compiled}
+	}
+	return s, nil
+}
+
+// expressions by name
+func (s *Store) List() map[string]string {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	expressions := make(map[string]string, len(s.filters))
+	for name, filter := range s.filters {
+		expressions[name] = filter.expression
+	}
+	return expressions
+}
+
+// return the expression and its compiled predicate, the boolean is false when the name is unknown
+func (s *Store) Get(name string) (string, func(any) bool, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	filter, ok := s.filters[name]
+	return filter.expression, filter.predicate, ok
+}
+
+// create or replace a filter, the expression is compiled before, the boolean is true when the filter is created
+func (s *Store) Put(name string, expression string) (bool, error) {
+	if err := checkName(name); err != nil {
+		return false, err
+	}
+	compiled, err := predicate.ParsePredicate(expression)
+	if err != nil {
+		return false, err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	previous, exists := s.filters[name]
+	if !exists && len(s.filters) >= s.maxCount {
+		return false, ErrTooMany
+	}
+	s.filters[name] = savedFilter{expression: expression, predicate: compiled}
+	if err = s.save(); err != nil {
+		if exists { // keep memory and file consistent
+			s.filters[name] = previous
+		} else {
+			delete(s.filters, name)
+		}
+		return false, err
+	}
+	return !exists, nil
+}
+
+// the boolean is false when the name is unknown
+func (s *Store) Delete(name string) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	previous, exists := s.filters[name]
+	if !exists {
+		return false, nil
+	}
+
+	delete(s.filters, name)
+	if err := s.save(); err != nil {
+		s.filters[name] = previous
+		return false, err
+	}
+	return true, nil
+}
+
+func checkName(name string) error {
+	if !validName.MatchString(name) {
+		return ErrInvalidName
+	}
+	if _, reserved := reservedNames[name]; reserved {
+		return ErrReservedName
+	}
+	return nil
+}
+
+func (e PersistError) Error() string {
+	return e.err.Error()
+}
+
+func (e PersistError) Unwrap() error {
+	return e.err
+}
+
+// write a temporary file then rename it, so the file is never partially written (called with the lock held)
+func (s *Store) save() error {
+	if err := s.write(); err != nil {
+		return PersistError{err: err}
+	}
+	return nil
+}
+
+func (s *Store) write() error {
+	if s.path == "" {
+		return nil
+	}
+
+	expressions := make(map[string]string, len(s.filters))
+	for name, filter := range s.filters {
+		expressions[name] = filter.expression
+	}
+	data, err := json.MarshalIndent(expressions, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "fail to encode saved filters")
+	}
+
+	tempFile, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return errors.Wrap(err, "fail to create saved filters file")
+	}
+	defer os.Remove(tempFile.Name()) // no effect after the rename
+
+	if _, err = tempFile.Write(data); err != nil {
+		tempFile.Close()
+		return errors.Wrap(err, "fail to write saved filters")
+	}
+	if err = tempFile.Close(); err != nil {
+		return errors.Wrap(err, "fail to write saved filters")
+	}
+	return errors.Wrap(os.Rename(tempFile.Name(), s.path), "fail to replace saved filters file")
+}
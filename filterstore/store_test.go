@@ -0,0 +1,147 @@
+package filterstore
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestLoadMissingFile(t *testing.T) {
+	store, err := Load(filepath.Join(t.TempDir(), "filters.json"), 10)
+	if err != nil {
+		t.Fatalf("unexpected error : %v", err)
+	}
+	if filters := store.List(); len(filters) != 0 {
+		t.Errorf("expected an empty store, got %v", filters)
+	}
+}
+
+func TestLoadRejectsInvalidFile(t *testing.T) {
+	testCases := map[string]string{
+		"invalid json":       `{"go": `,
+		"invalid name":       `{"go popular": "watchers_count > 10"}`,
+		"reserved name":      `{"explain": "watchers_count > 10"}`,
+		"invalid expression": `{"go": "watchers_count >"}`,
+	}
+	for name, content := range testCases {
+		path := filepath.Join(t.TempDir(), "filters.json")
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatalf("unexpected error : %v", err)
+		}
+		if _, err := Load(path, 10); err == nil {
+			t.Errorf("%s : expected an error", name)
+		}
+	}
+}
+
+func TestPersistAndReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "filters.json")
+	store, _ := Load(path, 10)
+
+	if created, err := store.Put("go", `"Go" in languages`); err != nil || !created {
+		t.Fatalf("expected a creation, got %v, %v", created, err)
+	}
+	if created, err := store.Put("popular", "watchers_count > 10"); err != nil || !created {
+		t.Fatalf("expected a creation, got %v, %v", created, err)
+	}
+	if created, err := store.Put("go", `"Go" in languages and forks_count > 1`); err != nil || created {
+		t.Fatalf("expected a replacement, got %v, %v", created, err)
+	}
+	if deleted, err := store.Delete("popular"); err != nil || !deleted {
+		t.Fatalf("expected a deletion, got %v, %v", deleted, err)
+	}
+	if deleted, err := store.Delete("popular"); err != nil || deleted {
+		t.Fatalf("expected an unknown filter, got %v, %v", deleted, err)
+	}
+
+	expected := map[string]string{"go": `"Go" in languages and forks_count > 1`}
+	reloaded, err := Load(path, 10)
+	if err != nil {
+		t.Fatalf("unexpected error : %v", err)
+	}
+	if filters := reloaded.List(); !reflect.DeepEqual(filters, expected) {
+		t.Errorf("expected %v, got %v", expected, filters)
+	}
+
+	// the predicate is compiled when loaded
+	expression, predicate, ok := reloaded.Get("go")
+	if !ok || expression != expected["go"] {
+		t.Fatalf("unexpected filter %q (found %v)", expression, ok)
+	}
+	if !predicate(map[string]any{"languages": map[string]any{"Go": 10.0}, "forks_count": 2.0}) {
+		t.Errorf("expected a match")
+	}
+}
+
+func TestPutChecks(t *testing.T) {
+	store, _ := Load("", 2)
+	store.Put("first", "true")
+	store.Put("second", "true")
+
+	testCases := []struct {
+		name       string
+		expression string
+		expected   error
+	}{
+		{name: "third", expression: "true", expected: ErrTooMany},
+		{name: "go popular", expression: "true", expected: ErrInvalidName},
+		{name: "", expression: "true", expected: ErrInvalidName},
+		{name: "validate", expression: "true", expected: ErrReservedName},
+		{name: "explain", expression: "true", expected: ErrReservedName},
+	}
+	for _, testCase := range testCases {
+		if _, err := store.Put(testCase.name, testCase.expression); !errors.Is(err, testCase.expected) {
+			t.Errorf("%q : expected %v, got %v", testCase.name, testCase.expected, err)
+		}
+	}
+
+	// replacing is allowed when the maximum is reached, an invalid expression is refused
+	if _, err := store.Put("first", "false"); err != nil {
+		t.Errorf("unexpected error : %v", err)
+	}
+	if _, err := store.Put("second", "watchers_count >"); err == nil {
+		t.Errorf("expected a compilation error")
+	}
+	expected := map[string]string{"first": "false", "second": "true"}
+	if filters := store.List(); !reflect.DeepEqual(filters, expected) {
+		t.Errorf("expected %v, got %v", expected, filters)
+	}
+}
+
+func TestRollbackOnPersistError(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "filters")
+	if err := os.Mkdir(dir, 0o700); err != nil {
+		t.Fatalf("unexpected error : %v", err)
+	}
+	store, _ := Load(filepath.Join(dir, "filters.json"), 10)
+	if _, err := store.Put("go", `"Go" in languages`); err != nil {
+		t.Fatalf("unexpected error : %v", err)
+	}
+
+	// the temporary file can not be created anymore
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatalf("unexpected error : %v", err)
+	}
+
+	var persistErr PersistError
+	if _, err := store.Put("popular", "watchers_count > 10"); !errors.As(err, &persistErr) {
+		t.Errorf("expected a PersistError, got %v", err)
+	}
+	if _, err := store.Put("go", "false"); !errors.As(err, &persistErr) {
+		t.Errorf("expected a PersistError, got %v", err)
+	}
+	if _, err := store.Delete("go"); !errors.As(err, &persistErr) {
+		t.Errorf("expected a PersistError, got %v", err)
+	}
+
+	expected := map[string]string{"go": `"Go" in languages`}
+	if filters := store.List(); !reflect.DeepEqual(filters, expected) {
+		t.Errorf("expected %v, got %v", expected, filters)
+	}
+	if _, predicate, _ := store.Get("go"); !predicate(map[string]any{"languages": map[string]any{"Go": 10.0}}) {
+		t.Errorf("expected the previous predicate")
+	}
+}
@@ -0,0 +1,139 @@
+package githubfake
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const recordedApiUrl = "https://api.github.com" // replaced by the url of the fake server when replayed
+
+type JsonObject = map[string]any
+
+// a recorded api response
+type Fixture struct {
+	Method string            `json:"method"`
+	Uri    string            `json:"uri"` // path with query
+	Status int               `json:"status"`
+	Header map[string]string `json:"header,omitempty"`
+	Body   json.RawMessage   `json:"body"`
+}
+
+// wrap a transport to save each JSON response as a Fixture file in Dir,
+// responses of credential endpoints and responses containing a token are never saved
+type Recorder struct {
+	Base    http.RoundTripper
+	Dir     string
+	OnError func(error) // optional, a failing save is reported but the response is returned anyway
+}
+
+var (
+	recordedHeaders = []string{"Link"}
+	// like the installation tokens of GitHub apps
+	credentialPaths = []string{"/access_tokens", "/app/installations"}
+)
+
+func (f Fixture) key() string {
+	return f.Method + " " + f.Uri
+}
+
+func (rec Recorder) RoundTrip(request *http.Request) (*http.Response, error) {
+	response, err := rec.Base.RoundTrip(request)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := io.ReadAll(response.Body)
+	response.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	response.Body = io.NopCloser(bytes.NewReader(data))
+
+	if !json.Valid(data) {
+		return response, nil // like compressed or html error pages
+	}
+	if isCredential(request.URL.Path, data) {
+		return response, nil
+	}
+
+	fixture := Fixture{Method: request.Method, Uri: request.URL.RequestURI(), Status: response.StatusCode, Body: data}
+	for _, name := range recordedHeaders {
+		if value := response.Header.Get(name); value != "" {
+			if fixture.Header == nil {
+				fixture.Header = map[string]string{}
+			}
+			fixture.Header[name] = value
+		}
+	}
+	if err = fixture.save(rec.Dir); err != nil && rec.OnError != nil {
+		rec.OnError(err) // recording is optional, it must not break the api call
+	}
+	return response, nil
+}
+
+func isCredential(path string, data []byte) bool {
+	for _, credentialPath := range credentialPaths {
+		if strings.Contains(path, credentialPath) {
+			return true
+		}
+	}
+
+	var object JsonObject
+	if json.Unmarshal(data, &object) != nil {
+		return false // not an object
+	}
+	_, hasToken := object["token"]
+	return hasToken
+}
+
+func (f Fixture) save(dir string) error {
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "fail to encode fixture")
+	}
+
+	hash := sha256.Sum256([]byte(f.key()))
+	fileName := filepath.Join(dir, hex.EncodeToString(hash[:8])+".json")
+	return errors.Wrap(os.WriteFile(fileName, data, 0o644), "fail to write fixture")
+}
+
+// read every fixture file of the directory
+func LoadFixtures(dir string) ([]Fixture, error) {
+	fileNames, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, errors.Wrap(err, "fail to list fixtures")
+	}
+
+	fixtures := make([]Fixture, 0, len(fileNames))
+	for _, fileName := range fileNames {
+		data, err := os.ReadFile(fileName)
+		if err != nil {
+			return nil, errors.Wrap(err, "fail to read fixture")
+		}
+
+		var fixture Fixture
+		if err = json.Unmarshal(data, &fixture); err != nil {
+			return nil, errors.Wrapf(err, "fail to parse fixture %s", fileName)
+		}
+		fixtures = append(fixtures, fixture)
+	}
+	return fixtures, nil
+}
+
+// urls of the recorded api are replaced to keep following links on the fake server
+func (s *Server) replay(w http.ResponseWriter, fixture Fixture, baseUrl string) {
+	header := http.Header{}
+	for name, value := range fixture.Header {
+		header.Set(name, strings.ReplaceAll(value, recordedApiUrl, baseUrl))
+	}
+	s.write(w, fixture.Status, bytes.ReplaceAll(fixture.Body, []byte(recordedApiUrl), []byte(baseUrl)), header)
+}
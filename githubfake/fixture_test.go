@@ -0,0 +1,74 @@
+package githubfake
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestRecorderSavesFixtures(t *testing.T) {
+	server := httptest.NewServer(New(Options{RepositoryCount: 5, EventCount: 20, RateLimit: 100}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	var saveErrors []error
+	client := &http.Client{Transport: Recorder{Base: http.DefaultTransport, Dir: dir, OnError: func(err error) {
+		saveErrors = append(saveErrors, err)
+	}}}
+	for _, path := range []string{"/events?per_page=10", "/repos/owner1/repo1", "/app/installations/1/access_tokens"} {
+		response, err := client.Get(server.URL + path)
+		if err != nil {
+			t.Fatalf("unexpected error : %v", err)
+		}
+		io.Copy(io.Discard, response.Body)
+		response.Body.Close()
+	}
+	if len(saveErrors) != 0 {
+		t.Fatalf("unexpected save errors : %v", saveErrors)
+	}
+
+	fixtures, err := LoadFixtures(dir)
+	if err != nil {
+		t.Fatalf("unexpected error : %v", err)
+	}
+	// the credential endpoint is not recorded
+	byUri := map[string]Fixture{}
+	for _, fixture := range fixtures {
+		byUri[fixture.Uri] = fixture
+	}
+	if len(byUri) != 2 {
+		t.Fatalf("expected 2 fixtures, got %v", byUri)
+	}
+	if events := byUri["/events?per_page=10"]; events.Status != http.StatusOK || events.Header["Link"] == "" {
+		t.Errorf("unexpected events fixture : %+v", events)
+	}
+	if repository := byUri["/repos/owner1/repo1"]; repository.Method != http.MethodGet || len(repository.Body) == 0 {
+		t.Errorf("unexpected repository fixture : %+v", repository)
+	}
+}
+
+func TestRecorderKeepsResponseWhenSaveFails(t *testing.T) {
+	server := httptest.NewServer(New(Options{RepositoryCount: 5, EventCount: 20, RateLimit: 100}))
+	defer server.Close()
+
+	var saveErrors []error
+	recorder := Recorder{Base: http.DefaultTransport, Dir: filepath.Join(t.TempDir(), "missing"), OnError: func(err error) {
+		saveErrors = append(saveErrors, err)
+	}}
+	for _, rec := range []Recorder{recorder, {Base: http.DefaultTransport, Dir: recorder.Dir}} { // with and without OnError
+		response, err := (&http.Client{Transport: rec}).Get(server.URL + "/repos/owner1/repo1")
+		if err != nil {
+			t.Fatalf("unexpected error : %v", err)
+		}
+		data, _ := io.ReadAll(response.Body)
+		response.Body.Close()
+		if response.StatusCode != http.StatusOK || len(data) == 0 {
+			t.Errorf("unexpected response %d : %s", response.StatusCode, data)
+		}
+	}
+	if len(saveErrors) != 1 {
+		t.Errorf("expected 1 save error, got %v", saveErrors)
+	}
+}
@@ -0,0 +1,269 @@
+package githubfake
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultPageSize = 30
+	maxPageSize     = 100
+)
+
+var eventTypes = []string{"PushEvent", "WatchEvent", "PullRequestEvent", "IssuesEvent", "ReleaseEvent", "CreateEvent"}
+
+type Options struct {
+	RepositoryCount int           // distinct repositories in the event stream
+	EventCount      int           // events available through pagination (GitHub return at most 300)
+	RateLimit       int           // initial remaining quota, calls are refused with 403 when it is consumed
+	Delay           time.Duration // added before each response
+	Errors          []ErrorRule
+	Fixtures        []Fixture // served (when matching) before generated responses
+}
+
+// answer with Status every Every matching call (every call when Every is lower than 2)
+type ErrorRule struct {
+	PathPrefix string
+	Status     int
+	Every      int
+}
+
+// deterministic fake of the GitHub REST API (events, repositories and languages)
+type Server struct {
+	options   Options
+	startedAt time.Time
+	remaining atomic.Int64
+	mutex     sync.Mutex
+	errors    []*errorState
+	fixtures  map[string]Fixture
+}
+
+type errorState struct {
+	rule  ErrorRule
+	count int
+}
+
+func New(options Options) *Server {
+	s := &Server{options: options, startedAt: time.Now(), fixtures: make(map[string]Fixture, len(options.Fixtures))}
+	s.remaining.Store(int64(options.RateLimit))
+	for _, rule := range options.Errors {
+		s.errors = append(s.errors, &errorState{rule: rule})
+	}
+	for _, fixture := range options.Fixtures {
+		s.fixtures[fixture.key()] = fixture
+	}
+	return s
+}
+
+// add an error rule while the server is running
+func (s *Server) Inject(rule ErrorRule) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.errors = append(s.errors, &errorState{rule: rule})
+}
+
+// remove all error rules
+func (s *Server) Heal() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.errors = nil
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	time.Sleep(s.options.Delay)
+
+	remaining := s.remaining.Add(-1)
+	if remaining < 0 {
+		s.remaining.Store(0)
+		s.send(w, http.StatusForbidden, JsonObject{"message": "API rate limit exceeded"}, nil)
+		return
+	}
+
+	if status := s.injectedStatus(r.URL.Path); status != 0 {
+		s.send(w, status, JsonObject{"message": http.StatusText(status)}, nil)
+		return
+	}
+
+	baseUrl := requestBaseUrl(r)
+	if fixture, ok := s.fixtures[r.Method+" "+r.URL.RequestURI()]; ok {
+		s.replay(w, fixture, baseUrl)
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		s.send(w, http.StatusMethodNotAllowed, JsonObject{"message": "Method not allowed"}, nil)
+		return
+	}
+
+	path := strings.Trim(r.URL.Path, "/")
+	parts := strings.Split(path, "/")
+	switch {
+	case path == "events" || strings.HasSuffix(path, "/events"):
+		s.serveEvents(w, r, baseUrl)
+	case len(parts) == 3 && parts[0] == "repos":
+		s.serveRepository(w, baseUrl, parts[1], parts[2])
+	case len(parts) == 4 && parts[0] == "repos" && parts[3] == "languages":
+		s.serveLanguages(w, parts[2])
+	default:
+		s.send(w, http.StatusNotFound, JsonObject{"message": "Not Found"}, nil)
+	}
+}
+
+func (s *Server) injectedStatus(path string) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, state := range s.errors {
+		if !strings.HasPrefix(path, state.rule.PathPrefix) {
+			continue
+		}
+
+		state.count++
+		if state.rule.Every < 2 || state.count%state.rule.Every == 0 {
+			return state.rule.Status
+		}
+	}
+	return 0
+}
+
+func (s *Server) serveEvents(w http.ResponseWriter, r *http.Request, baseUrl string) {
+	query := r.URL.Query()
+	pageSize, _ := strconv.Atoi(query.Get("per_page"))
+	if pageSize <= 0 {
+		pageSize = defaultPageSize
+	}
+	if pageSize > maxPageSize {
+		pageSize = maxPageSize
+	}
+	page, _ := strconv.Atoi(query.Get("page"))
+	if page <= 0 {
+		page = 1
+	}
+
+	events := []JsonObject{}
+	if s.options.RepositoryCount <= 0 {
+		s.send(w, http.StatusOK, events, nil)
+		return
+	}
+	for index := (page - 1) * pageSize; index < page*pageSize && index < s.options.EventCount; index++ {
+		events = append(events, s.event(baseUrl, index))
+	}
+
+	header := http.Header{}
+	lastPage := (s.options.EventCount + pageSize - 1) / pageSize
+	if page < lastPage {
+		pageUrl := baseUrl + r.URL.Path + "?per_page=" + strconv.Itoa(pageSize) + "&page="
+		header.Set("Link", fmt.Sprintf("<%s%d>; rel=\"next\", <%s%d>; rel=\"last\"", pageUrl, page+1, pageUrl, lastPage))
+	}
+	s.send(w, http.StatusOK, events, header)
+}
+
+// events are ordered from the most recent
+func (s *Server) event(baseUrl string, index int) JsonObject {
+	repoIndex := index % s.options.RepositoryCount
+	owner, name := repositoryName(repoIndex)
+	return JsonObject{
+		"id":         strconv.Itoa(s.options.EventCount - index),
+		"type":       eventTypes[index%len(eventTypes)],
+		"actor":      JsonObject{"id": index % 17, "login": "user" + strconv.Itoa(index%17)},
+		"repo":       JsonObject{"id": repoIndex, "name": owner + "/" + name, "url": baseUrl + "/repos/" + owner + "/" + name},
+		"payload":    JsonObject{},
+		"public":     true,
+		"created_at": s.startedAt.Add(-time.Duration(index) * time.Minute).UTC().Format(time.RFC3339),
+	}
+}
+
+func (s *Server) serveRepository(w http.ResponseWriter, baseUrl string, owner string, name string) {
+	repoIndex, ok := repositoryIndex(name)
+	if !ok || repoIndex >= s.options.RepositoryCount {
+		s.send(w, http.StatusNotFound, JsonObject{"message": "Not Found"}, nil)
+		return
+	}
+
+	repoUrl := baseUrl + "/repos/" + owner + "/" + name
+	repository := JsonObject{
+		"id":                repoIndex,
+		"name":              name,
+		"full_name":         owner + "/" + name,
+		"owner":             JsonObject{"login": owner, "type": "Organization"},
+		"description":       "fake repository " + strconv.Itoa(repoIndex),
+		"forks_count":       repoIndex % 50,
+		"watchers_count":    repoIndex * 3,
+		"stargazers_count":  repoIndex * 3,
+		"open_issues_count": repoIndex % 7,
+		"topics":            []string{"fake", eventTypes[repoIndex%len(eventTypes)]},
+		"url":               repoUrl,
+		"languages_url":     repoUrl + "/languages",
+	}
+	if repoIndex%4 != 0 { // some repositories without license
+		repository["license"] = JsonObject{"key": []string{"mit", "apache-2.0", "gpl-3.0"}[repoIndex%3], "name": "fake license"}
+	}
+	if repoIndex%2 == 0 { // the others are owned by users
+		repository["organization"] = JsonObject{"login": owner}
+	} else {
+		repository["owner"] = JsonObject{"login": owner, "type": "User"}
+	}
+	s.send(w, http.StatusOK, repository, nil)
+}
+
+func (s *Server) serveLanguages(w http.ResponseWriter, name string) {
+	repoIndex, ok := repositoryIndex(name)
+	if !ok || repoIndex >= s.options.RepositoryCount {
+		s.send(w, http.StatusNotFound, JsonObject{"message": "Not Found"}, nil)
+		return
+	}
+
+	languages := JsonObject{"Go": 1000 + repoIndex}
+	if repoIndex%3 == 0 {
+		languages["Python"] = 10 * repoIndex
+	}
+	if repoIndex%5 == 0 {
+		languages["Shell"] = repoIndex
+	}
+	s.send(w, http.StatusOK, languages, nil)
+}
+
+func (s *Server) send(w http.ResponseWriter, status int, value any, header http.Header) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		status, data = http.StatusInternalServerError, []byte(`{"message":"fail to encode response"}`)
+	}
+	s.write(w, status, data, header)
+}
+
+func (s *Server) write(w http.ResponseWriter, status int, data []byte, header http.Header) {
+	for name, values := range header {
+		w.Header()[name] = values
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("X-RateLimit-Limit", strconv.Itoa(s.options.RateLimit))
+	w.Header().Set("X-RateLimit-Remaining", strconv.FormatInt(s.remaining.Load(), 10))
+	w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(s.startedAt.Add(time.Hour).Unix(), 10))
+	w.WriteHeader(status)
+	w.Write(data)
+}
+
+func requestBaseUrl(r *http.Request) string {
+	if r.TLS == nil {
+		return "http://" + r.Host
+	}
+	return "https://" + r.Host
+}
+
+// repositories are spread over 5 owners
+func repositoryName(repoIndex int) (string, string) {
+	return "owner" + strconv.Itoa(repoIndex%5), "repo" + strconv.Itoa(repoIndex)
+}
+
+func repositoryIndex(name string) (int, bool) {
+	repoIndex, err := strconv.Atoi(strings.TrimPrefix(name, "repo"))
+	return repoIndex, err == nil && strings.HasPrefix(name, "repo") && repoIndex >= 0
+}
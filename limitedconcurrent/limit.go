@@ -2,9 +2,10 @@ package limitedconcurrent
 
 type empty = struct{}
 
-func LaunchLimited[T any](senders []func(chan<- T), limit int) []T {
+// a panicking sender does not stop the others, its panic is reported to the optional handlers
+func LaunchLimited[T any](senders []func(chan<- T), limit int, onPanic ...PanicHandler) []T {
 	outputChan := make(chan T, len(senders))
-	go manageLaunch(outputChan, senders, limit)
+	go manageLaunch(outputChan, senders, limit, onPanic)
 
 	values := make([]T, 0, len(senders))
 	for value := range outputChan {
@@ -13,7 +14,7 @@ func LaunchLimited[T any](senders []func(chan<- T), limit int) []T {
 	return values
 }
 
-func manageLaunch[T any](outputChan chan<- T, senders []func(chan<- T), limit int) {
+func manageLaunch[T any](outputChan chan<- T, senders []func(chan<- T), limit int, onPanic []PanicHandler) {
 	guard := make(chan empty, limit) // initialize a limited number of "concurrent slot"
 	done := func() {
 		<-guard // release a concurrent slot
@@ -23,8 +24,8 @@ func manageLaunch[T any](outputChan chan<- T, senders []func(chan<- T), limit in
 		guard <- empty{}     // take a concurrent slot (block until one is available)
 		senderCopy := sender // avoid closure capture
 		go func() {
-			defer done() // use defer because sender can panic
-			senderCopy(outputChan)
+			defer done()
+			safeSend(senderCopy, outputChan, onPanic)
 		}()
 	}
 
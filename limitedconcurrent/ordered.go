@@ -0,0 +1,68 @@
+package limitedconcurrent
+
+import "errors"
+
+// returned (as Result.Err) by tasks built with FromSender when the sender did not send any value
+var ErrNoValue = errors.New("task did not send any value")
+
+type Result[T any] struct {
+	Index   int // position of the task in the launched slice
+	Value   T
+	Present bool // false when the task failed
+	Err     error
+}
+
+// adapt a sender to the task signature of the ordered and streaming variants (only the first sent value is kept),
+// a sender panic is returned as a *PanicError
+func FromSender[T any](sender func(chan<- T)) func() (T, error) {
+	return func() (T, error) {
+		valueChan := make(chan T)
+		var panicErr *PanicError
+		go func() {
+			defer close(valueChan)
+			safeSend(sender, valueChan, []PanicHandler{func(err *PanicError) {
+				panicErr = err // read after the close of valueChan
+			}})
+		}()
+
+		var value T
+		received := false
+		for sent := range valueChan { // drain the channel, so a sender can not block
+			if !received {
+				value, received = sent, true
+			}
+		}
+		if panicErr != nil {
+			return value, panicErr
+		}
+		if !received {
+			return value, ErrNoValue
+		}
+		return value, nil
+	}
+}
+
+// results are aligned with tasks (results[i] is the result of tasks[i]), a task panic is returned as a *PanicError
+func LaunchLimitedOrdered[T any](tasks []func() (T, error), limit int) []Result[T] {
+	results := make([]Result[T], len(tasks))
+	for result := range LaunchLimitedStream(tasks, limit) {
+		results[result.Index] = result
+	}
+	return results
+}
+
+// results are sent as soon as their task ends, the channel is closed when all tasks are done
+func LaunchLimitedStream[T any](tasks []func() (T, error), limit int) <-chan Result[T] {
+	senders := make([]func(chan<- Result[T]), 0, len(tasks))
+	for index, task := range tasks {
+		indexCopy, taskCopy := index, task // avoid closure capture
+		senders = append(senders, func(resultChan chan<- Result[T]) {
+			value, err := safeCall(taskCopy)
+			resultChan <- Result[T]{Index: indexCopy, Value: value, Present: err == nil, Err: err}
+		})
+	}
+
+	resultChan := make(chan Result[T], len(tasks))
+	go manageLaunch(resultChan, senders, limit, nil) // task panics are already in results
+	return resultChan
+}
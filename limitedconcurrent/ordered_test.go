@@ -0,0 +1,62 @@
+package limitedconcurrent
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLaunchLimitedOrderedAlignResults(t *testing.T) {
+	taskErr := errors.New("task failure")
+	probe := &concurrencyProbe{}
+	tasks := make([]func() (int, error), 0, 8)
+	for i := 0; i < 8; i++ {
+		index := i
+		tasks = append(tasks, func() (int, error) {
+			// the first tasks end last
+			probe.run(time.Duration(8-index) * 5 * time.Millisecond)
+			if index == 5 {
+				return 0, taskErr
+			}
+			return index * 10, nil
+		})
+	}
+
+	results := LaunchLimitedOrdered(tasks, 3)
+	if len(results) != len(tasks) {
+		t.Fatalf("expected %d results, got %d", len(tasks), len(results))
+	}
+	for index, result := range results {
+		if result.Index != index {
+			t.Errorf("expected index %d, got %d", index, result.Index)
+		}
+		if index == 5 {
+			if result.Present || result.Err != taskErr {
+				t.Errorf("expected a missing value with the task error at index 5, got %+v", result)
+			}
+			continue
+		}
+		if !result.Present || result.Err != nil || result.Value != index*10 {
+			t.Errorf("expected value %d at index %d, got %+v", index*10, index, result)
+		}
+	}
+	if max := probe.max.Load(); max > 3 {
+		t.Errorf("expected at most 3 concurrent tasks, got %d", max)
+	}
+}
+
+func TestLaunchLimitedOrderedWithSenders(t *testing.T) {
+	tasks := []func() (string, error){
+		FromSender(func(outputChan chan<- string) { outputChan <- "a" }),
+		FromSender(func(chan<- string) {}),
+		FromSender(func(outputChan chan<- string) { outputChan <- "c" }),
+	}
+
+	results := LaunchLimitedOrdered(tasks, 2)
+	if !results[0].Present || results[0].Value != "a" || !results[2].Present || results[2].Value != "c" {
+		t.Errorf("expected values a and c at index 0 and 2, got %+v", results)
+	}
+	if results[1].Present || results[1].Err != ErrNoValue {
+		t.Errorf("expected ErrNoValue at index 1, got %+v", results[1])
+	}
+}
@@ -0,0 +1,42 @@
+package limitedconcurrent
+
+import (
+	"fmt"
+	"runtime/debug"
+)
+
+// a recovered task panic, with the stack of the panicking goroutine
+type PanicError struct {
+	Value any
+	Stack []byte
+}
+
+// receive the panics of tasks which have no result to carry them (it can be called concurrently)
+type PanicHandler func(err *PanicError)
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("task panic : %v\n%s", e.Value, e.Stack)
+}
+
+// run the task and convert its panic into a *PanicError
+func safeCall[T any](task func() (T, error)) (value T, err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = &PanicError{Value: recovered, Stack: debug.Stack()}
+		}
+	}()
+	return task()
+}
+
+// run the sender and report its panic to the handlers (the panic is dropped when there is none)
+func safeSend[T any](sender func(chan<- T), outputChan chan<- T, onPanic []PanicHandler) {
+	_, err := safeCall(func() (empty, error) {
+		sender(outputChan)
+		return empty{}, nil
+	})
+	if panicErr, ok := err.(*PanicError); ok {
+		for _, handler := range onPanic {
+			handler(panicErr)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package limitedconcurrent
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const panicValue = "boom"
+
+func checkPanicError(t *testing.T, err error) {
+	t.Helper()
+	var panicErr *PanicError
+	if !errors.As(err, &panicErr) {
+		t.Fatalf("expected a *PanicError, got %v", err)
+	}
+	if panicErr.Value != panicValue {
+		t.Errorf("expected panic value %q, got %v", panicValue, panicErr.Value)
+	}
+	// the stack is the one of the panicking task
+	if !strings.Contains(string(panicErr.Stack), "panic_test.go") {
+		t.Errorf("expected the stack of the task, got %s", panicErr.Stack)
+	}
+}
+
+// collect the reported panics
+type panicRecorder struct {
+	mutex  sync.Mutex
+	errors []*PanicError
+}
+
+func (r *panicRecorder) handle(err *PanicError) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.errors = append(r.errors, err)
+}
+
+func (r *panicRecorder) count() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return len(r.errors)
+}
+
+// poll the condition until it is true or the timeout is reached
+func eventually(t *testing.T, condition func() bool, message string) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal(message)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestPoolKeepsWorkingAfterPanic(t *testing.T) {
+	recorder := &panicRecorder{}
+	pool := NewPool(1, recorder.handle)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := Submit(ctx, pool, func() (int, error) {
+		panic(panicValue)
+	}).Await(ctx)
+	checkPanicError(t, err)
+	if count := recorder.count(); count != 1 {
+		t.Errorf("expected the panic handler to be called once, got %d", count)
+	}
+	eventually(t, func() bool { return pool.Stats().Panicked == 1 }, "expected one panicked task in stats")
+
+	// the single worker survived the panic
+	for i := 0; i < 3; i++ {
+		value, err := Submit(ctx, pool, func() (int, error) {
+			return 42, nil
+		}).Await(ctx)
+		if err != nil || value != 42 {
+			t.Fatalf("expected 42 after the panic, got %d (%v)", value, err)
+		}
+	}
+	eventually(t, func() bool { return pool.Stats().Completed == 4 }, "expected four completed tasks in stats")
+	if stats := pool.Stats(); stats.Panicked != 1 {
+		t.Errorf("expected one panicked task in stats, got %d", stats.Panicked)
+	}
+}
+
+func TestLaunchLimitedReportsPanic(t *testing.T) {
+	recorder := &panicRecorder{}
+	senders := []func(chan<- int){
+		func(outputChan chan<- int) { outputChan <- 1 },
+		func(chan<- int) { panic(panicValue) },
+		func(outputChan chan<- int) { outputChan <- 3 },
+	}
+
+	values := LaunchLimited(senders, 2, recorder.handle)
+	if len(values) != 2 {
+		t.Errorf("expected the values of the two other senders, got %v", values)
+	}
+	if count := recorder.count(); count != 1 {
+		t.Fatalf("expected the panic handler to be called once, got %d", count)
+	}
+	checkPanicError(t, recorder.errors[0])
+}
+
+func TestFromSenderReturnsPanic(t *testing.T) {
+	_, err := FromSender(func(chan<- int) { panic(panicValue) })()
+	checkPanicError(t, err)
+
+	if _, err = FromSender(func(chan<- int) {})(); err != ErrNoValue {
+		t.Errorf("expected ErrNoValue, got %v", err)
+	}
+
+	value, err := FromSender(func(outputChan chan<- int) {
+		outputChan <- 1
+		outputChan <- 2 // only the first value is kept
+	})()
+	if err != nil || value != 1 {
+		t.Errorf("expected 1, got %d (%v)", value, err)
+	}
+}
+
+func TestLaunchLimitedStreamReturnsPanic(t *testing.T) {
+	tasks := []func() (int, error){
+		func() (int, error) { return 1, nil },
+		func() (int, error) { panic(panicValue) },
+		func() (int, error) { return 3, nil },
+	}
+
+	results := map[int]Result[int]{}
+	for result := range LaunchLimitedStream(tasks, 2) {
+		results[result.Index] = result
+	}
+	if len(results) != len(tasks) {
+		t.Fatalf("expected a result by task, got %v", results)
+	}
+	if result := results[1]; result.Present {
+		t.Errorf("expected the panicking task to have no value, got %v", result)
+	}
+	checkPanicError(t, results[1].Err)
+	for _, index := range []int{0, 2} {
+		if result := results[index]; !result.Present || result.Value != index+1 || result.Err != nil {
+			t.Errorf("expected value %d at index %d, got %+v", index+1, index, result)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package limitedconcurrent
+
+import (
+	"context"
+	"time"
+)
+
+type Priority int
+
+const (
+	Background Priority = iota // like automatic refreshes
+	OnDemand                   // like manual refreshes, run before background tasks
+	priorityCount
+)
+
+type priorityKey struct{}
+
+type PoolStats struct {
+	Queued    int
+	Running   int
+	Completed int // including the panicked ones
+	Panicked  int
+}
+
+// long-lived workers shared by all batches, queued tasks are started by priority (then in submission order)
+type Pool struct {
+	submitChan chan<- job
+	statsChan  <-chan PoolStats
+	onPanic    []PanicHandler
+}
+
+type job struct {
+	priority Priority
+	run      func() bool // return true when the task panicked
+}
+
+// wait the result of a submitted task
+type Future[T any] struct {
+	done   <-chan empty // closed when result is set
+	result *Result[T]
+}
+
+// a task panic is returned by its Future as a *PanicError and reported to the optional handlers,
+// the worker keeps running
+func NewPool(workerCount int, onPanic ...PanicHandler) Pool {
+	submitChan := make(chan job)
+	statsChan := make(chan PoolStats)
+	go managePool(submitChan, statsChan, workerCount)
+	return Pool{submitChan: submitChan, statsChan: statsChan, onPanic: onPanic}
+}
+
+func (p Pool) Stats() PoolStats {
+	return <-p.statsChan
+}
+
+// the priority is read from the context (Background by default), like the optional ProgressTracker
+func Submit[T any](ctx context.Context, pool Pool, task func() (T, error)) Future[T] {
+	done := make(chan empty)
+	result := &Result[T]{}
+	tracker := progressFrom(ctx)
+	tracker.submitted()
+	pool.submitChan <- job{priority: PriorityFrom(ctx), run: func() bool {
+		defer close(done)
+		start := time.Now()
+		tracker.started()
+		result.Value, result.Err = safeCall(task)
+		result.Present = result.Err == nil
+		tracker.ended(time.Since(start), !result.Present)
+
+		panicErr, panicked := result.Err.(*PanicError)
+		if panicked {
+			for _, handler := range pool.onPanic {
+				handler(panicErr)
+			}
+		}
+		return panicked
+	}}
+	return Future[T]{done: done, result: result}
+}
+
+// block until the task ends or the context is done
+func (f Future[T]) Await(ctx context.Context) (T, error) {
+	select {
+	case <-f.done:
+		return f.result.Value, f.result.Err
+	case <-ctx.Done():
+		var zero T
+		return zero, ctx.Err()
+	}
+}
+
+// submit all tasks and send results as soon as their task ends, the channel is closed when all tasks are done
+func SubmitAll[T any](ctx context.Context, pool Pool, tasks []func() (T, error)) <-chan Result[T] {
+	resultChan := make(chan Result[T], len(tasks))
+	futures := make([]Future[T], 0, len(tasks))
+	for index, task := range tasks {
+		indexCopy, taskCopy := index, task // avoid closure capture
+		futures = append(futures, Submit(ctx, pool, func() (T, error) {
+			value, err := safeCall(taskCopy) // the result of a panicking task is sent too
+			resultChan <- Result[T]{Index: indexCopy, Value: value, Present: err == nil, Err: err}
+			return value, err
+		}))
+	}
+
+	go func() {
+		for _, future := range futures {
+			<-future.done // tasks always end, even when the context is done
+		}
+		close(resultChan)
+	}()
+	return resultChan
+}
+
+// like LaunchLimited with the workers of the pool
+func LaunchPooled[T any](ctx context.Context, pool Pool, senders []func(chan<- T)) []T {
+	outputChan := make(chan T)
+	futures := make([]Future[empty], 0, len(senders))
+	for _, sender := range senders {
+		senderCopy := sender // avoid closure capture
+		futures = append(futures, Submit(ctx, pool, func() (empty, error) {
+			senderCopy(outputChan)
+			return empty{}, nil
+		}))
+	}
+
+	go func() {
+		for _, future := range futures {
+			<-future.done
+		}
+		close(outputChan)
+	}()
+
+	values := make([]T, 0, len(senders))
+	for value := range outputChan {
+		values = append(values, value)
+	}
+	return values
+}
+
+func WithPriority(ctx context.Context, priority Priority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, priority)
+}
+
+func PriorityFrom(ctx context.Context) Priority {
+	priority, _ := ctx.Value(priorityKey{}).(Priority) // Background when missing
+	if priority < Background || priority >= priorityCount {
+		return Background
+	}
+	return priority
+}
+
+func managePool(submitChan <-chan job, statsChan chan<- PoolStats, workerCount int) {
+	jobChan := make(chan func() bool)
+	doneChan := make(chan bool) // true when the task panicked
+	for i := 0; i < workerCount; i++ {
+		go func() {
+			for run := range jobChan {
+				doneChan <- run()
+			}
+		}()
+	}
+
+	var queues [priorityCount][]func() bool
+	stats := PoolStats{}
+	for {
+		// the most prioritary job is offered to idle workers
+		var jobChanOrNil chan<- func() bool // nil (so never selected) when there is no queued job
+		var next func() bool
+		nextPriority := Priority(0)
+		for priority := priorityCount - 1; priority >= 0; priority-- {
+			if len(queues[priority]) != 0 {
+				jobChanOrNil, next, nextPriority = jobChan, queues[priority][0], priority
+				break
+			}
+		}
+
+		select {
+		case submitted := <-submitChan:
+			queues[submitted.priority] = append(queues[submitted.priority], submitted.run)
+			stats.Queued++
+		case jobChanOrNil <- next:
+			queues[nextPriority] = queues[nextPriority][1:]
+			stats.Queued--
+			stats.Running++
+		case panicked := <-doneChan:
+			stats.Running--
+			stats.Completed++
+			if panicked {
+				stats.Panicked++
+			}
+		case statsChan <- stats:
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package limitedconcurrent
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPoolRunOnDemandTasksFirst(t *testing.T) {
+	pool := NewPool(1)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// occupy the single worker, so the next tasks are queued
+	gate := make(chan empty)
+	blocking := Submit(ctx, pool, func() (empty, error) {
+		<-gate
+		return empty{}, nil
+	})
+	eventually(t, func() bool { return pool.Stats().Running == 1 }, "expected the blocking task to run")
+
+	var mutex sync.Mutex
+	var order []string
+	record := func(name string) func() (string, error) {
+		return func() (string, error) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			order = append(order, name)
+			return name, nil
+		}
+	}
+	backgroundCtx := WithPriority(ctx, Background)
+	futures := []Future[string]{
+		Submit(backgroundCtx, pool, record("background1")),
+		Submit(backgroundCtx, pool, record("background2")),
+		Submit(WithPriority(ctx, OnDemand), pool, record("onDemand")),
+		Submit(ctx, pool, record("background3")), // Background by default
+	}
+	if stats := pool.Stats(); stats.Queued != 4 || stats.Running != 1 || stats.Completed != 0 {
+		t.Errorf("expected 4 queued and 1 running tasks, got %+v", stats)
+	}
+
+	close(gate)
+	if _, err := blocking.Await(ctx); err != nil {
+		t.Fatalf("unexpected error : %v", err)
+	}
+	for _, future := range futures {
+		if _, err := future.Await(ctx); err != nil {
+			t.Fatalf("unexpected error : %v", err)
+		}
+	}
+
+	expected := []string{"onDemand", "background1", "background2", "background3"}
+	for index, name := range expected {
+		if order[index] != name {
+			t.Fatalf("expected order %v, got %v", expected, order)
+		}
+	}
+	eventually(t, func() bool {
+		stats := pool.Stats()
+		return stats.Queued == 0 && stats.Running == 0 && stats.Completed == 5 && stats.Panicked == 0
+	}, "expected 5 completed tasks in stats")
+}
+
+func TestFutureAwaitContext(t *testing.T) {
+	pool := NewPool(1)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	gate := make(chan empty)
+	future := Submit(ctx, pool, func() (int, error) {
+		<-gate
+		return 1, nil
+	})
+
+	shortCtx, shortCancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer shortCancel()
+	if _, err := future.Await(shortCtx); err != context.DeadlineExceeded {
+		t.Errorf("expected the deadline error, got %v", err)
+	}
+
+	// the task is not cancelled, its result is still available
+	close(gate)
+	if value, err := future.Await(ctx); err != nil || value != 1 {
+		t.Errorf("expected 1, got %d (%v)", value, err)
+	}
+}
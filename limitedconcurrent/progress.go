@@ -0,0 +1,120 @@
+package limitedconcurrent
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type progressKey struct{}
+
+type Progress struct {
+	Total           int // submitted tasks
+	Started         int
+	Completed       int // including the failed ones
+	Failed          int // tasks which returned an error (like ErrNoValue or a *PanicError)
+	Elapsed         time.Duration
+	TaskDuration    time.Duration // cumulated duration of completed tasks
+	MaxTaskDuration time.Duration
+}
+
+// called after each change, never concurrently (it should be fast)
+type ProgressObserver func(progress Progress)
+
+// aggregate the progress of all tasks submitted to a pool with its context (across batches)
+type ProgressTracker struct {
+	mutex    sync.Mutex
+	start    time.Time
+	end      time.Time // zero until Finish is called
+	progress Progress
+	observer ProgressObserver
+}
+
+// observer can be nil
+func NewProgressTracker(observer ProgressObserver) *ProgressTracker {
+	return &ProgressTracker{start: time.Now(), observer: observer}
+}
+
+func WithProgress(ctx context.Context, tracker *ProgressTracker) context.Context {
+	return context.WithValue(ctx, progressKey{}, tracker)
+}
+
+func progressFrom(ctx context.Context) *ProgressTracker {
+	tracker, _ := ctx.Value(progressKey{}).(*ProgressTracker) // nil when missing
+	return tracker
+}
+
+func (p Progress) MeanTaskDuration() time.Duration {
+	if p.Completed == 0 {
+		return 0
+	}
+	return p.TaskDuration / time.Duration(p.Completed)
+}
+
+func (t *ProgressTracker) Progress() Progress {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	progress := t.progress
+	progress.Elapsed = t.elapsed()
+	return progress
+}
+
+// freeze the elapsed duration
+func (t *ProgressTracker) Finish() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.end.IsZero() {
+		t.end = time.Now()
+	}
+}
+
+func (t *ProgressTracker) elapsed() time.Duration {
+	if t.end.IsZero() {
+		return time.Since(t.start)
+	}
+	return t.end.Sub(t.start)
+}
+
+// methods are safe with a nil tracker (nothing is tracked)
+func (t *ProgressTracker) update(change func(progress *Progress)) {
+	if t == nil {
+		return
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	change(&t.progress)
+	if t.observer != nil {
+		progress := t.progress
+		progress.Elapsed = t.elapsed()
+		t.observer(progress)
+	}
+}
+
+func (t *ProgressTracker) submitted() {
+	t.update(func(progress *Progress) {
+		progress.Total++
+	})
+}
+
+func (t *ProgressTracker) started() {
+	t.update(func(progress *Progress) {
+		progress.Started++
+	})
+}
+
+func (t *ProgressTracker) ended(duration time.Duration, failed bool) {
+	t.update(func(progress *Progress) {
+		progress.Completed++
+		if failed {
+			progress.Failed++
+		}
+		progress.TaskDuration += duration
+		if duration > progress.MaxTaskDuration {
+			progress.MaxTaskDuration = duration
+		}
+	})
+}
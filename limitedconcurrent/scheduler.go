@@ -0,0 +1,238 @@
+package limitedconcurrent
+
+import (
+	"context"
+	"time"
+)
+
+type Limits struct {
+	Concurrency int     // maximum number of running tasks
+	Rate        float64 // maximum number of tasks started by second, unlimited when not positive
+	Burst       int     // tasks which can be started at once after an idle period (at least 1)
+}
+
+// combine a concurrency cap with a token bucket rate limit, the limits can be changed at runtime
+// and a scheduler can be shared by any number of batches
+//
+// scheduling is hierarchical : a sub-task acquiring with the context returned to its parent borrow
+// the slot of its parent (which is waiting for it) and only consume a rate token, the slot is lent to
+// one sub-task at a time (the others wait for it or for a free slot), so nested tasks use the same
+// budget without deadlock
+type Scheduler struct {
+	acquireChan chan<- slotRequest
+	cancelChan  chan<- slotRequest
+	releaseChan chan<- *slotGrant
+	limitsChan  chan<- Limits
+}
+
+type slotRequest struct {
+	grantChan chan empty
+	grant     *slotGrant
+}
+
+// only read and written by the manager goroutine
+type slotGrant struct {
+	parent   *slotGrant // nil for a task acquiring without a slot in its context
+	lent     bool       // the slot is used by a sub-task
+	borrowed bool       // the slot is the one of the parent
+	granted  bool
+}
+
+type slotKey struct{}
+
+type heldSlot struct {
+	acquireChan chan<- slotRequest // identify the scheduler
+	grant       *slotGrant
+}
+
+func NewScheduler(limits Limits) Scheduler {
+	acquireChan := make(chan slotRequest)
+	cancelChan := make(chan slotRequest)
+	releaseChan := make(chan *slotGrant)
+	limitsChan := make(chan Limits)
+	go manageSchedule(acquireChan, cancelChan, releaseChan, limitsChan, limits)
+	return Scheduler{acquireChan: acquireChan, cancelChan: cancelChan, releaseChan: releaseChan, limitsChan: limitsChan}
+}
+
+// block until the task can start (return an error when the context is done before),
+// the returned context must be used by the task for its sub-tasks and release must be called when it ends
+func (s Scheduler) Acquire(ctx context.Context) (context.Context, func(), error) {
+	if err := ctx.Err(); err != nil {
+		return ctx, nil, err // a grant could win the select below
+	}
+
+	grant := &slotGrant{}
+	if held, ok := ctx.Value(slotKey{}).(heldSlot); ok && held.acquireChan == s.acquireChan {
+		grant.parent = held.grant
+	}
+	request := slotRequest{grantChan: make(chan empty, 1), grant: grant} // never block the manager
+	s.acquireChan <- request
+	select {
+	case <-request.grantChan:
+	case <-ctx.Done():
+		s.cancelChan <- request // release the slot when it was granted meanwhile
+		return ctx, nil, ctx.Err()
+	}
+
+	release := func() {
+		s.releaseChan <- grant
+	}
+	return context.WithValue(ctx, slotKey{}, heldSlot{acquireChan: s.acquireChan, grant: grant}), release, nil
+}
+
+func (s Scheduler) SetLimits(limits Limits) {
+	s.limitsChan <- limits
+}
+
+// like LaunchLimited with the limits of the scheduler, senders are not started when the context is done
+func LaunchScheduled[T any](ctx context.Context, scheduler Scheduler, senders []func(chan<- T), onPanic ...PanicHandler) []T {
+	scheduledSenders := make([]func(chan<- T), 0, len(senders))
+	for _, sender := range senders {
+		senderCopy := sender // avoid closure capture
+		scheduledSenders = append(scheduledSenders, func(outputChan chan<- T) {
+			_, release, err := scheduler.Acquire(ctx)
+			if err != nil {
+				return
+			}
+			defer release()
+			senderCopy(outputChan)
+		})
+	}
+	// the scheduler does the limitation, each sender wait in its own goroutine
+	return LaunchLimited(scheduledSenders, len(scheduledSenders)+1, onPanic...)
+}
+
+func manageSchedule(acquireChan <-chan slotRequest, cancelChan <-chan slotRequest, releaseChan <-chan *slotGrant, limitsChan <-chan Limits, limits Limits) {
+	var queue []slotRequest
+	var nestedQueue []slotRequest // served first, their parents hold slots
+	running := 0
+
+	tokens := float64(limits.Burst)
+	lastRefill := time.Now()
+	refill := func() {
+		now := time.Now()
+		if limits.Rate > 0 {
+			tokens += now.Sub(lastRefill).Seconds() * limits.Rate
+			burst := float64(limits.Burst)
+			if burst < 1 {
+				burst = 1
+			}
+			if tokens > burst {
+				tokens = burst
+			}
+		}
+		lastRefill = now
+	}
+	takeToken := func() bool {
+		if limits.Rate <= 0 {
+			return true
+		}
+		if tokens < 1 {
+			return false
+		}
+		tokens--
+		return true
+	}
+	// a nested request use the slot of its parent when it is not lent, or a free slot
+	grantable := func(request slotRequest) bool {
+		return !request.grant.parent.lent || running < limits.Concurrency
+	}
+	grant := func(request slotRequest) {
+		if parent := request.grant.parent; parent != nil && !parent.lent {
+			parent.lent = true
+			request.grant.borrowed = true
+		} else {
+			running++
+		}
+		request.grant.granted = true
+		request.grantChan <- empty{}
+	}
+	release := func(grant *slotGrant) {
+		if grant.borrowed {
+			grant.parent.lent = false
+		} else {
+			running--
+		}
+	}
+
+	timer := time.NewTimer(0)
+	stopTimer(timer)
+	timerSet := false
+	for {
+		refill()
+		waiting := false
+		remaining := nestedQueue[:0]
+		for index, request := range nestedQueue {
+			if !grantable(request) {
+				remaining = append(remaining, request)
+				continue
+			}
+			if !takeToken() {
+				waiting = true
+				remaining = append(remaining, nestedQueue[index:]...)
+				break
+			}
+			grant(request)
+		}
+		nestedQueue = remaining
+		for len(queue) != 0 && running < limits.Concurrency {
+			if !takeToken() {
+				waiting = true
+				break
+			}
+			grant(queue[0])
+			queue = queue[1:]
+		}
+
+		// wake up when the next token is available
+		if timerSet {
+			stopTimer(timer)
+			timerSet = false
+		}
+		if limits.Rate > 0 && waiting {
+			timer.Reset(time.Duration((1 - tokens) / limits.Rate * float64(time.Second)))
+			timerSet = true
+		}
+
+		select {
+		case request := <-acquireChan:
+			if request.grant.parent != nil {
+				nestedQueue = append(nestedQueue, request)
+			} else {
+				queue = append(queue, request)
+			}
+		case request := <-cancelChan:
+			if index := indexOf(nestedQueue, request); index >= 0 {
+				nestedQueue = append(nestedQueue[:index], nestedQueue[index+1:]...)
+			} else if index = indexOf(queue, request); index >= 0 {
+				queue = append(queue[:index], queue[index+1:]...)
+			} else if request.grant.granted {
+				release(request.grant) // granted before the cancellation
+			}
+		case grant := <-releaseChan:
+			release(grant)
+		case limits = <-limitsChan:
+		case <-timer.C:
+			timerSet = false
+		}
+	}
+}
+
+func indexOf(queue []slotRequest, request slotRequest) int {
+	for index, queued := range queue {
+		if queued.grantChan == request.grantChan {
+			return index
+		}
+	}
+	return -1
+}
+
+// stop the timer and drain its channel, so it can be safely reset
+func stopTimer(timer *time.Timer) {
+	if !timer.Stop() {
+		select {
+		case <-timer.C:
+		default:
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package limitedconcurrent
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// track the maximum number of simultaneously running calls
+type concurrencyProbe struct {
+	running atomic.Int32
+	max     atomic.Int32
+}
+
+func (p *concurrencyProbe) run(duration time.Duration) {
+	current := p.running.Add(1)
+	for {
+		max := p.max.Load()
+		if current <= max || p.max.CompareAndSwap(max, current) {
+			break
+		}
+	}
+	time.Sleep(duration)
+	p.running.Add(-1)
+}
+
+func acquireOrFail(t *testing.T, ctx context.Context, scheduler Scheduler) (context.Context, func()) {
+	t.Helper()
+	ctx, release, err := scheduler.Acquire(ctx)
+	if err != nil {
+		t.Fatalf("unexpected acquire error : %v", err)
+	}
+	return ctx, release
+}
+
+func TestNestedCallsShareTheConcurrencyCap(t *testing.T) {
+	scheduler := NewScheduler(Limits{Concurrency: 2})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	parentCtx, releaseParent := acquireOrFail(t, ctx, scheduler)
+	probe := &concurrencyProbe{}
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, release := acquireOrFail(t, parentCtx, scheduler)
+			defer release()
+			probe.run(20 * time.Millisecond)
+		}()
+	}
+	wg.Wait()
+	releaseParent()
+
+	// the waiting parent lend its slot to one child, the other slot is the only free one
+	if max := probe.max.Load(); max > 2 {
+		t.Errorf("expected at most 2 concurrent nested calls, got %d", max)
+	}
+}
+
+func TestDeepNestingDoesNotDeadlock(t *testing.T) {
+	scheduler := NewScheduler(Limits{Concurrency: 1})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	parentCtx, releaseParent := acquireOrFail(t, ctx, scheduler)
+	childCtx, releaseChild := acquireOrFail(t, parentCtx, scheduler)
+	_, releaseGrandChild := acquireOrFail(t, childCtx, scheduler)
+	releaseGrandChild()
+	releaseChild()
+	releaseParent()
+
+	// the slot is free again
+	_, release := acquireOrFail(t, ctx, scheduler)
+	release()
+}
+
+func TestSchedulerConcurrencyCap(t *testing.T) {
+	scheduler := NewScheduler(Limits{Concurrency: 3})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	probe := &concurrencyProbe{}
+	var wg sync.WaitGroup
+	for i := 0; i < 12; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, release := acquireOrFail(t, ctx, scheduler)
+			defer release()
+			probe.run(10 * time.Millisecond)
+		}()
+	}
+	wg.Wait()
+
+	if max := probe.max.Load(); max > 3 {
+		t.Errorf("expected at most 3 concurrent calls, got %d", max)
+	}
+}
+
+func TestSchedulerRateCap(t *testing.T) {
+	scheduler := NewScheduler(Limits{Concurrency: 100, Rate: 20, Burst: 1})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 11; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, release := acquireOrFail(t, ctx, scheduler)
+			release()
+		}()
+	}
+	wg.Wait()
+
+	// the burst token then one token every 50ms, with a tolerance for timers
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond || elapsed > 3*time.Second {
+		t.Errorf("expected about 500ms for 11 calls at 20 by second, got %v", elapsed)
+	}
+}
+
+func TestSchedulerBurst(t *testing.T) {
+	scheduler := NewScheduler(Limits{Concurrency: 100, Rate: 1, Burst: 5})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		_, release := acquireOrFail(t, ctx, scheduler)
+		release()
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("expected the burst to be immediate, got %v", elapsed)
+	}
+
+	// the bucket is empty
+	shortCtx, shortCancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer shortCancel()
+	if _, _, err := scheduler.Acquire(shortCtx); err == nil {
+		t.Error("expected the call to wait for a token")
+	}
+}
+
+func TestSchedulerSetLimits(t *testing.T) {
+	scheduler := NewScheduler(Limits{Concurrency: 1, Rate: 0.5, Burst: 1})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, releaseFirst := acquireOrFail(t, ctx, scheduler)
+	grantedChan := make(chan func())
+	go func() {
+		_, release := acquireOrFail(t, ctx, scheduler)
+		grantedChan <- release
+	}()
+
+	select {
+	case <-grantedChan:
+		t.Fatal("expected the second call to wait for a slot and a token")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// more slots and a faster rate, without releasing the first slot
+	scheduler.SetLimits(Limits{Concurrency: 2, Rate: 100, Burst: 1})
+	select {
+	case release := <-grantedChan:
+		release()
+	case <-time.After(time.Second):
+		t.Fatal("expected the second call to start after the limits change")
+	}
+	releaseFirst()
+}
+
+func TestSchedulerCancelledAcquire(t *testing.T) {
+	scheduler := NewScheduler(Limits{Concurrency: 1})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, release := acquireOrFail(t, ctx, scheduler)
+	cancelledCtx, cancelAcquire := context.WithCancel(ctx)
+	cancelAcquire()
+	if _, _, err := scheduler.Acquire(cancelledCtx); err == nil {
+		t.Fatal("expected an error with a cancelled context")
+	}
+	release()
+
+	// the cancelled request did not keep the slot
+	_, release = acquireOrFail(t, ctx, scheduler)
+	release()
+}
+
+func TestLaunchScheduled(t *testing.T) {
+	scheduler := NewScheduler(Limits{Concurrency: 2})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	probe := &concurrencyProbe{}
+	senders := make([]func(chan<- int), 0, 6)
+	for i := 0; i < 6; i++ {
+		value := i
+		senders = append(senders, func(outputChan chan<- int) {
+			probe.run(10 * time.Millisecond)
+			outputChan <- value
+		})
+	}
+
+	if values := LaunchScheduled(ctx, scheduler, senders); len(values) != len(senders) {
+		t.Errorf("expected %d values, got %v", len(senders), values)
+	}
+	if max := probe.max.Load(); max > 2 {
+		t.Errorf("expected at most 2 concurrent senders, got %d", max)
+	}
+
+	// senders are not started once the context is done
+	cancel()
+	if values := LaunchScheduled(ctx, scheduler, senders); len(values) != 0 {
+		t.Errorf("expected no value with a done context, got %v", values)
+	}
+}
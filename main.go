@@ -8,7 +8,8 @@ import (
 
 	"github.com/Scalingo/go-handlers"
 	"github.com/Scalingo/go-utils/logger"
-	"github.com/dvaumoron/sclng-backend-test-v1/predicate"
+	"github.com/dvaumoron/sclng-backend-test-v1/filterstore"
+	"github.com/dvaumoron/sclng-backend-test-v1/githubfake"
 	"github.com/dvaumoron/sclng-backend-test-v1/repositoryservice"
 )
 
@@ -17,6 +18,7 @@ const (
 	jsonContentType = "application/json"
 
 	parseFilterErrorMsg = "can not parse filter"
+	notReadyMsg         = "repositories are not retrieved yet"
 )
 
 func main() {
@@ -28,13 +30,94 @@ func main() {
 		os.Exit(1)
 	}
 
-	repoService := repositoryservice.Make(log, cfg.EventApiUrl, cfg.EventPageSize, cfg.Refresh, cfg.MaxCall, cfg.AccessToken)
+	strategy, err := repositoryservice.MakeRefreshStrategy(cfg.RefreshMode, cfg.Refresh, cfg.RefreshTtl, cfg.RefreshIdle, cfg.RefreshMax)
+	if err != nil {
+		log.WithError(err).Error("Fail to initialize refresh strategy")
+		os.Exit(1)
+	}
+
+	auth, err := cfg.makeAuthentication()
+	if err != nil {
+		log.WithError(err).Error("Fail to initialize authentication")
+		os.Exit(1)
+	}
+	if !auth.Authenticated() {
+		cfg.scaleDownUnauthenticated()
+		log.WithField("page_size", cfg.EventPageSize).WithField("max_page", cfg.EventMaxPage).WithField("target_count", cfg.TargetCount).Warn("No authentication, retrieval is scaled down")
+	}
+
+	httpOptions := repositoryservice.HttpOptions{
+		Timeout: cfg.HttpTimeout, MaxConn: cfg.MaxCall, ProxyUrl: cfg.HttpProxyUrl, CaFile: cfg.HttpCaFile,
+	}
+	if cfg.HttpRecordDir != "" {
+		log.WithField("dir", cfg.HttpRecordDir).Warn("Api responses are recorded")
+		httpOptions.WrapTransport = func(base http.RoundTripper) http.RoundTripper {
+			return githubfake.Recorder{Base: base, Dir: cfg.HttpRecordDir, OnError: func(err error) {
+				log.WithError(err).Error("Fail to record api response")
+			}}
+		}
+	}
+	httpClient, err := repositoryservice.MakeHttpClient(httpOptions)
+	if err != nil {
+		log.WithError(err).Error("Fail to initialize http client")
+		os.Exit(1)
+	}
+
+	enrichers, err := repositoryservice.MakeEnrichers(cfg.Enrichers, cfg.EnricherTtls)
+	if err != nil {
+		log.WithError(err).Error("Fail to initialize enrichers")
+		os.Exit(1)
+	}
+
+	filterStore, err := filterstore.Load(cfg.FiltersFile, cfg.FiltersMax)
+	if err != nil {
+		log.WithError(err).Error("Fail to load saved filters")
+		os.Exit(1)
+	}
+
+	repoService := repositoryservice.Make(log, repositoryservice.Options{
+		Forge:             cfg.Forge,
+		Sources:           repositoryservice.ParseEventSources(cfg.EventApiUrls, cfg.EventApiQuotas, cfg.TargetCount),
+		IncludedTypes:     cfg.EventTypes,
+		ExcludedTypes:     cfg.ExcludedEventTypes,
+		EventPageSize:     cfg.EventPageSize,
+		MaxPage:           cfg.EventMaxPage,
+		Backend:           cfg.Backend,
+		GraphqlUrl:        cfg.GraphqlUrl,
+		GraphqlBatch:      cfg.GraphqlBatch,
+		ApiUrl:            cfg.ApiUrl,
+		Enrichers:         enrichers,
+		MinRemaining:      cfg.EnricherMinRemaining,
+		Strategy:          strategy,
+		OverlapPolicy:     cfg.RefreshOverlap,
+		RefreshDeadline:   cfg.RefreshDeadline,
+		MaxCall:           cfg.MaxCall,
+		CallRate:          cfg.CallRate,
+		CallBurst:         cfg.CallBurst,
+		ThrottleRemaining: cfg.ThrottleRemaining,
+		Auth:              auth,
+		HttpClient:        httpClient,
+		UserAgent:         cfg.UserAgent,
+	})
 
 	log.Info("Initializing routes")
 	// Initialize web server and configure /ping and /repos routes
 	router := handlers.NewRouter(log)
 	router.HandleFunc("/ping", pongHandler)
-	router.HandleFunc("/repos", makeReposHandler(repoService))
+	router.HandleFunc("/repos", makeReposHandler(repoService, filterStore))
+	router.HandleFunc("/status", makeStatusHandler(repoService))
+	router.HandleFunc("/filters", makeListFiltersHandler(filterStore)).Methods(http.MethodGet)
+	// registered before /filters/{name} to take precedence
+	router.HandleFunc("/filters/validate", makeValidateFilterHandler(repoService)).Methods(http.MethodGet)
+	router.HandleFunc("/filters/explain", makeExplainFilterHandler(repoService)).Methods(http.MethodGet)
+	router.HandleFunc("/filters/{name}", makeGetFilterHandler(filterStore)).Methods(http.MethodGet)
+	if cfg.AdminToken == "" {
+		log.Info("No admin token, admin routes and changes of saved filters are disabled")
+	} else {
+		router.HandleFunc("/admin/refresh", makeAdminAuth(cfg.AdminToken, makeRefreshHandler(repoService))).Methods(http.MethodPost)
+		router.HandleFunc("/filters/{name}", makeAdminAuth(cfg.AdminToken, makePutFilterHandler(filterStore))).Methods(http.MethodPut)
+		router.HandleFunc("/filters/{name}", makeAdminAuth(cfg.AdminToken, makeDeleteFilterHandler(filterStore))).Methods(http.MethodDelete)
+	}
 
 	log = log.WithField("port", cfg.Port)
 	log.Info("Listening...")
@@ -57,42 +140,45 @@ func pongHandler(w http.ResponseWriter, r *http.Request, _ map[string]string) er
 	return nil
 }
 
-func makeReposHandler(repoService repositoryservice.RepositoryService) func(w http.ResponseWriter, r *http.Request, _ map[string]string) error {
+func makeReposHandler(repoService repositoryservice.RepositoryService, filterStore *filterstore.Store) func(w http.ResponseWriter, r *http.Request, _ map[string]string) error {
 	return func(w http.ResponseWriter, r *http.Request, _ map[string]string) error {
+		snapshot, ready := repoService.TrySnapshot()
+		if !ready {
+			writeJson(r, w, http.StatusServiceUnavailable, map[string]any{"status": notReadyMsg, "refreshing": snapshot.Refreshing})
+			return nil
+		}
+
 		log := logger.Get(r.Context())
 		w.Header().Add(contentType, jsonContentType)
 		w.WriteHeader(http.StatusOK)
 
-		repositories := repoService.List()
-		result := make(map[string]any, 3)
-
-		filters := r.URL.Query()["filter"]
-		var filterErrors []string
-		switch len(filters) {
-		case 0:
-			// no filter
-		default:
-			filterErrors = append(filterErrors, "only first filter is used")
-			fallthrough
-		case 1:
-			result["filter"] = filters[0]
-			predicate, err := predicate.ParsePredicate(filters[0])
-			if err != nil {
-				log.WithError(err).Error(parseFilterErrorMsg)
-				filterErrors = append(filterErrors, parseFilterErrorMsg)
-				break
-			}
-
-			filtered := make([]repositoryservice.JsonObject, 0, len(repositories))
-			for _, repository := range repositories {
-				if predicate(repository) {
-					filtered = append(filtered, repository)
-				}
-			}
-			repositories = filtered
+		result := make(map[string]any, 12)
+		result["refresh_id"] = snapshot.RefreshId
+		result["fetched_at"] = snapshot.FetchedAt
+		result["refresh_duration"] = snapshot.RefreshDuration.String()
+		result["refreshing"] = snapshot.Refreshing
+		result["sources"] = snapshot.Sources()
+		result["fetch_errors"] = map[string]int{
+			"event_pages": snapshot.EventPageErrorCount, "repositories": snapshot.RepositoryErrorCount,
+			"enrichments": snapshot.EnrichmentErrorCount, "skipped_enrichments": snapshot.EnrichmentSkipCount,
+		}
+		result["refresh_stats"] = map[string]int{
+			"skipped_overruns": snapshot.Stats.SkippedOverruns, "queued_overruns": snapshot.Stats.QueuedOverruns,
+			"deadline_exceeded": snapshot.Stats.DeadlineExceeded,
+		}
+		if snapshot.DeadlineExceeded || snapshot.Incomplete {
+			result["partial"] = true
+		}
+		if snapshot.Incomplete {
+			result["incomplete"] = true // the first retrieval is still running
+		}
+		if shortfallReasons := snapshot.ShortfallReasons(); len(shortfallReasons) != 0 {
+			result["shortfall_reasons"] = shortfallReasons
 		}
 
-		result["repositories"] = repositories
+		filterPredicate, filterErrors := parseRepoFilters(log, r.URL.Query(), filterStore, result)
+		// returned repositories are copies, the cache can not be altered
+		result["repositories"] = snapshot.Filter(filterPredicate)
 		if len(filterErrors) != 0 {
 			result["filter_errors"] = filterErrors
 		}
@@ -106,3 +192,12 @@ func makeReposHandler(repoService repositoryservice.RepositoryService) func(w ht
 		return nil
 	}
 }
+
+func writeJson(r *http.Request, w http.ResponseWriter, status int, value any) {
+	w.Header().Add(contentType, jsonContentType)
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		logger.Get(r.Context()).WithError(err).Error("Fail to encode JSON")
+	}
+}
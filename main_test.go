@@ -0,0 +1,331 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Scalingo/go-handlers"
+	"github.com/dvaumoron/sclng-backend-test-v1/filterstore"
+	"github.com/dvaumoron/sclng-backend-test-v1/githubfake"
+	"github.com/dvaumoron/sclng-backend-test-v1/repositoryservice"
+	"github.com/sirupsen/logrus"
+)
+
+// service without authentication reading 40 repositories from a fake api
+func startService(t *testing.T, fakeOptions githubfake.Options) repositoryservice.RepositoryService {
+	t.Helper()
+	fakeOptions.RepositoryCount, fakeOptions.EventCount, fakeOptions.RateLimit = 40, 100, 100000
+	server := httptest.NewServer(githubfake.New(fakeOptions))
+	t.Cleanup(server.Close)
+
+	auth, err := repositoryservice.MakeAuthentication(repositoryservice.NoAuth, nil, "", "", nil)
+	if err != nil {
+		t.Fatalf("unexpected error : %v", err)
+	}
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	return repositoryservice.Make(log, repositoryservice.Options{
+		Forge:           repositoryservice.GithubForge,
+		Sources:         repositoryservice.ParseEventSources([]string{server.URL + "/events"}, nil, 40),
+		EventPageSize:   10,
+		MaxPage:         10,
+		Backend:         repositoryservice.RestBackend,
+		ApiUrl:          server.URL,
+		Strategy:        repositoryservice.FixedRefresh(time.Hour),
+		OverlapPolicy:   repositoryservice.SkipOverlap,
+		RefreshDeadline: 10 * time.Second,
+		MaxCall:         10,
+		Auth:            auth,
+		HttpClient:      server.Client(),
+		UserAgent:       "test",
+	})
+}
+
+func callRepos(t *testing.T, handler handlers.HandlerFunc, target string) (int, map[string]any) {
+	t.Helper()
+	return callHandler(t, handler, httptest.NewRequest(http.MethodGet, target, nil), nil)
+}
+
+// the parsed result is nil when the response has no body
+func callHandler(t *testing.T, handler handlers.HandlerFunc, request *http.Request, vars map[string]string) (int, map[string]any) {
+	t.Helper()
+	recorder := httptest.NewRecorder()
+	if err := handler(recorder, request, vars); err != nil {
+		t.Fatalf("unexpected error : %v", err)
+	}
+
+	var result map[string]any
+	if recorder.Body.Len() == 0 {
+		return recorder.Code, nil
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &result); err != nil {
+		t.Fatalf("fail to parse response %q : %v", recorder.Body.String(), err)
+	}
+	return recorder.Code, result
+}
+
+func repositoriesOf(t *testing.T, result map[string]any) []map[string]any {
+	t.Helper()
+	values, ok := result["repositories"].([]any)
+	if !ok {
+		t.Fatalf("expected repositories in %v", result)
+	}
+	repositories := make([]map[string]any, 0, len(values))
+	for _, value := range values {
+		repositories = append(repositories, value.(map[string]any))
+	}
+	return repositories
+}
+
+func TestReposHandler(t *testing.T) {
+	repoService := startService(t, githubfake.Options{})
+	filterStore, _ := filterstore.Load("", 10)
+	if _, err := filterStore.Put("shell", `"Shell" in languages`); err != nil {
+		t.Fatalf("unexpected error : %v", err)
+	}
+	handler := makeReposHandler(repoService, filterStore)
+	repoService.Snapshot() // wait for the first retrieval
+
+	status, result := callRepos(t, handler, "/repos")
+	if status != http.StatusOK || result["refresh_id"] != float64(1) || len(repositoriesOf(t, result)) != 40 {
+		t.Fatalf("expected the 40 repositories of the first refresh, got %d : %v", status, result)
+	}
+	if _, ok := result["filter_errors"]; ok {
+		t.Errorf("unexpected filter errors : %v", result["filter_errors"])
+	}
+
+	testCases := []struct {
+		query string
+		count int
+		check func(map[string]any) bool
+	}{
+		// repo1, repo6, ..., repo36
+		{query: "owner=owner1", count: 8, check: func(repository map[string]any) bool { return repository["owner"] == "owner1" }},
+		// index multiple of 3
+		{query: "filter='Python'%20in%20languages", count: 14},
+		// index multiple of 5 or of 3
+		{query: "filter='Shell'%20in%20languages&filter='Python'%20in%20languages&filter_mode=or", count: 19},
+		{query: "saved=shell", count: 8},
+		{query: "saved=shell&exclude=owner%20==%20'owner0'", count: 0},
+	}
+	for _, testCase := range testCases {
+		status, result := callRepos(t, handler, "/repos?"+testCase.query)
+		if status != http.StatusOK {
+			t.Errorf("%s : unexpected status %d", testCase.query, status)
+			continue
+		}
+		if filterErrors, ok := result["filter_errors"]; ok {
+			t.Errorf("%s : unexpected filter errors : %v", testCase.query, filterErrors)
+		}
+		repositories := repositoriesOf(t, result)
+		if len(repositories) != testCase.count {
+			t.Errorf("%s : expected %d repositories, got %d", testCase.query, testCase.count, len(repositories))
+		}
+		for _, repository := range repositories {
+			if testCase.check != nil && !testCase.check(repository) {
+				t.Errorf("%s : unexpected repository %v", testCase.query, repository)
+			}
+		}
+	}
+}
+
+func TestReposHandlerReportsFilterErrors(t *testing.T) {
+	repoService := startService(t, githubfake.Options{})
+	filterStore, _ := filterstore.Load("", 10)
+	handler := makeReposHandler(repoService, filterStore)
+	repoService.Snapshot()
+
+	// invalid filters are ignored
+	status, result := callRepos(t, handler, "/repos?filter=forks_count%20%3E&saved=unknown&filter=forks_count%20%3E=%2032")
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status %d", status)
+	}
+	if repositories := repositoriesOf(t, result); len(repositories) != 8 { // repo32 to repo39
+		t.Errorf("expected 8 repositories, got %d", len(repositories))
+	}
+	filterErrors, _ := result["filter_errors"].([]any)
+	if len(filterErrors) != 2 {
+		t.Fatalf("expected 2 filter errors, got %v", result["filter_errors"])
+	}
+	parameters := map[any]bool{}
+	for _, filterErr := range filterErrors {
+		parameters[filterErr.(map[string]any)["parameter"]] = true
+	}
+	if !parameters["filter"] || !parameters["saved"] {
+		t.Errorf("unexpected filter errors : %v", filterErrors)
+	}
+}
+
+func TestReposHandlerReportsQueryErrors(t *testing.T) {
+	repoService := startService(t, githubfake.Options{})
+	filterStore, _ := filterstore.Load("", 10)
+	handler := makeReposHandler(repoService, filterStore)
+	repoService.Snapshot()
+
+	// the simple parameters are ignored when one is invalid
+	status, result := callRepos(t, handler, "/repos?owner=owner1&min_watchers=abc")
+	if status != http.StatusOK || len(repositoriesOf(t, result)) != 40 {
+		t.Fatalf("expected the 40 repositories, got %d : %v", status, result)
+	}
+	if _, ok := result["query_expression"]; ok {
+		t.Errorf("unexpected query expression : %v", result["query_expression"])
+	}
+	filterErrors, _ := result["filter_errors"].([]any)
+	if len(filterErrors) != 1 {
+		t.Fatalf("expected 1 filter error, got %v", result["filter_errors"])
+	}
+	if filterErr := filterErrors[0].(map[string]any); filterErr["parameter"] != "min_watchers" || filterErr["expression"] != "abc" {
+		t.Errorf("unexpected filter error : %v", filterErr)
+	}
+
+	status, result = callRepos(t, handler, "/repos?owner=owner1&owner=owner2&topic=FAKE&min_watchers=60")
+	if status != http.StatusOK || result["query_expression"] != `"fake" in topics and (owner == "owner1" or owner == "owner2") and watchers_count >= 60` {
+		t.Fatalf("unexpected response, got %d : %v", status, result)
+	}
+	if repositories := repositoriesOf(t, result); len(repositories) != 8 { // repo21 to repo37 (watchers_count is 3 times the index)
+		t.Errorf("expected 8 repositories, got %d", len(repositories))
+	}
+}
+
+func TestReposHandlerNotReady(t *testing.T) {
+	repoService := startService(t, githubfake.Options{Delay: 300 * time.Millisecond})
+	filterStore, _ := filterstore.Load("", 10)
+	handler := makeReposHandler(repoService, filterStore)
+
+	status, result := callRepos(t, handler, "/repos")
+	if status != http.StatusServiceUnavailable || result["status"] != notReadyMsg || result["refreshing"] != true {
+		t.Errorf("expected a not ready response, got %d : %v", status, result)
+	}
+}
+
+func TestFilterChangesHandlers(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "filters.json")
+	filterStore, _ := filterstore.Load(path, 2)
+	putHandler := makeAdminAuth("secret", makePutFilterHandler(filterStore))
+	deleteHandler := makeAdminAuth("secret", makeDeleteFilterHandler(filterStore))
+	getHandler := makeGetFilterHandler(filterStore)
+
+	call := func(handler handlers.HandlerFunc, method string, name string, body string, authorization string) (int, map[string]any) {
+		request := httptest.NewRequest(method, "/filters/"+name, strings.NewReader(body))
+		if authorization != "" {
+			request.Header.Set("Authorization", authorization)
+		}
+		return callHandler(t, handler, request, map[string]string{"name": name})
+	}
+
+	testCases := []struct {
+		handler       handlers.HandlerFunc
+		method        string
+		name          string
+		body          string
+		authorization string
+		expected      int
+	}{
+		{handler: putHandler, method: http.MethodPut, name: "go", body: `{"expression": "true"}`, expected: http.StatusUnauthorized},
+		{handler: putHandler, method: http.MethodPut, name: "go", body: `{"expression": "true"}`, authorization: "Bearer wrong", expected: http.StatusUnauthorized},
+		{handler: deleteHandler, method: http.MethodDelete, name: "go", authorization: "secret", expected: http.StatusUnauthorized},
+		{handler: putHandler, method: http.MethodPut, name: "go", body: `{"expression": "'Go' in languages"}`, authorization: "Bearer secret", expected: http.StatusCreated},
+		{handler: putHandler, method: http.MethodPut, name: "go", body: `{"expression": "'Go' in languages and forks_count > 1"}`, authorization: "Bearer secret", expected: http.StatusOK},
+		{handler: putHandler, method: http.MethodPut, name: "popular", body: `{"expression": "watchers_count >"}`, authorization: "Bearer secret", expected: http.StatusBadRequest},
+		{handler: putHandler, method: http.MethodPut, name: "popular", body: `{"filter": "true"}`, authorization: "Bearer secret", expected: http.StatusBadRequest},
+		{handler: putHandler, method: http.MethodPut, name: "popular", body: `{"expression": "` + strings.Repeat(" ", maxFilterBodySize) + `true"}`, authorization: "Bearer secret", expected: http.StatusBadRequest},
+		{handler: putHandler, method: http.MethodPut, name: "go%20popular", body: `{"expression": "true"}`, authorization: "Bearer secret", expected: http.StatusBadRequest},
+		{handler: putHandler, method: http.MethodPut, name: "explain", body: `{"expression": "true"}`, authorization: "Bearer secret", expected: http.StatusBadRequest},
+		{handler: putHandler, method: http.MethodPut, name: "popular", body: `{"expression": "watchers_count > 10"}`, authorization: "Bearer secret", expected: http.StatusCreated},
+		{handler: putHandler, method: http.MethodPut, name: "third", body: `{"expression": "true"}`, authorization: "Bearer secret", expected: http.StatusConflict},
+		{handler: deleteHandler, method: http.MethodDelete, name: "popular", authorization: "Bearer secret", expected: http.StatusNoContent},
+		{handler: deleteHandler, method: http.MethodDelete, name: "popular", authorization: "Bearer secret", expected: http.StatusNotFound},
+		{handler: getHandler, method: http.MethodGet, name: "popular", expected: http.StatusNotFound},
+	}
+	for index, testCase := range testCases {
+		if status, result := call(testCase.handler, testCase.method, testCase.name, testCase.body, testCase.authorization); status != testCase.expected {
+			t.Errorf("case %d (%s %s) : expected status %d, got %d : %v", index, testCase.method, testCase.name, testCase.expected, status, result)
+		}
+	}
+
+	// changes are persisted
+	status, result := call(getHandler, http.MethodGet, "go", "", "")
+	if status != http.StatusOK || result["expression"] != "'Go' in languages and forks_count > 1" {
+		t.Errorf("unexpected saved filter, got %d : %v", status, result)
+	}
+	reloaded, err := filterstore.Load(path, 2)
+	if err != nil {
+		t.Fatalf("unexpected error : %v", err)
+	}
+	if filters := reloaded.List(); len(filters) != 1 || filters["go"] != "'Go' in languages and forks_count > 1" {
+		t.Errorf("unexpected persisted filters : %v", filters)
+	}
+}
+
+func TestValidateAndExplainHandlers(t *testing.T) {
+	repoService := startService(t, githubfake.Options{})
+	validateHandler := makeValidateFilterHandler(repoService)
+	explainHandler := makeExplainFilterHandler(repoService)
+	repoService.Snapshot()
+
+	status, result := callRepos(t, validateHandler, "/filters/validate?expression=starz%20%3E%201")
+	if status != http.StatusOK || result["valid"] != true || result["schema_checked"] != true || result["result_type"] != "bool" {
+		t.Errorf("unexpected validation, got %d : %v", status, result)
+	}
+	if unknownFields, _ := result["unknown_fields"].([]any); len(unknownFields) != 1 || unknownFields[0] != "starz" {
+		t.Errorf("unexpected unknown fields : %v", result["unknown_fields"])
+	}
+
+	status, result = callRepos(t, validateHandler, "/filters/validate?expression=forks_count%20%3E")
+	errorsJson, _ := result["errors"].([]any)
+	if status != http.StatusOK || result["valid"] != false || len(errorsJson) != 1 {
+		t.Fatalf("expected an invalid expression, got %d : %v", status, result)
+	}
+	if issue := errorsJson[0].(map[string]any); issue["line"] != float64(1) || issue["column"] != float64(13) {
+		t.Errorf("unexpected error position : %v", issue)
+	}
+
+	status, result = callRepos(t, explainHandler, "/filters/explain?expression=forks_count%20%3E%201&sample=3")
+	explained, _ := result["repositories"].([]any)
+	if status != http.StatusOK || len(explained) != 3 {
+		t.Fatalf("expected 3 explained repositories, got %d : %v", status, result)
+	}
+	for _, value := range explained {
+		repository := value.(map[string]any)
+		if evaluations, _ := repository["evaluations"].([]any); len(evaluations) != 2 {
+			t.Errorf("expected 2 evaluations, got %v", repository)
+		}
+	}
+
+	badRequests := []string{
+		"/filters/explain", "/filters/explain?expression=forks_count%20%3E", "/filters/explain?expression=true&sample=0",
+		"/filters/explain?expression=true&sample=51", "/filters/explain?expression=true&sample=abc",
+	}
+	for _, target := range badRequests {
+		if status, result := callRepos(t, explainHandler, target); status != http.StatusBadRequest {
+			t.Errorf("%s : expected status 400, got %d : %v", target, status, result)
+		}
+	}
+	if status, result := callRepos(t, validateHandler, "/filters/validate"); status != http.StatusBadRequest {
+		t.Errorf("expected status 400, got %d : %v", status, result)
+	}
+}
+
+func TestValidateAndExplainHandlersNotReady(t *testing.T) {
+	repoService := startService(t, githubfake.Options{Delay: 300 * time.Millisecond})
+
+	// only the syntax is checked before the first retrieval
+	status, result := callRepos(t, makeValidateFilterHandler(repoService), "/filters/validate?expression=starz%20%3E%201")
+	if status != http.StatusOK || result["valid"] != true || result["schema_checked"] != false {
+		t.Errorf("unexpected validation, got %d : %v", status, result)
+	}
+	if _, ok := result["unknown_fields"]; ok {
+		t.Errorf("unexpected unknown fields : %v", result["unknown_fields"])
+	}
+
+	status, result = callRepos(t, makeExplainFilterHandler(repoService), "/filters/explain?expression=true")
+	if status != http.StatusServiceUnavailable || result["status"] != notReadyMsg {
+		t.Errorf("expected a not ready response, got %d : %v", status, result)
+	}
+}
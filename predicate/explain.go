@@ -0,0 +1,219 @@
+package predicate
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+
+	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/ast"
+	"github.com/expr-lang/expr/checker"
+	"github.com/expr-lang/expr/conf"
+	"github.com/expr-lang/expr/file"
+	"github.com/expr-lang/expr/parser"
+	"github.com/expr-lang/expr/vm"
+)
+
+const notBoolMsg = "the result is not a boolean, no repository will match"
+
+type Issue struct {
+	Message string
+	Line    int // 0 when there is no position
+	Column  int // 1-based, like in the error message
+}
+
+type Validation struct {
+	Errors        []Issue // the expression can not be used as filter when not empty
+	Warnings      []Issue // the expression compile but evaluations against the schema fail or never match
+	ResultType    string  // "unknown" when it depends on the evaluated values
+	UnknownFields []string
+}
+
+type Evaluation struct {
+	Expression string
+	Value      any
+	Err        error
+}
+
+// evaluate each sub-expression, they are compiled once
+type Explainer struct {
+	steps []explainStep // in evaluation order, the whole expression is the last one
+}
+
+type explainStep struct {
+	expression string
+	program    *vm.Program
+}
+
+// the schema gives an example value of each field (the result type and the unknown fields are only checked with it)
+func Validate(expression string, schema map[string]any) Validation {
+	validation := Validation{ResultType: "unknown"}
+	if _, err := expr.Compile(expression); err != nil {
+		validation.Errors = []Issue{makeIssue(err)}
+		return validation
+	}
+
+	tree, err := parser.Parse(expression)
+	if err != nil { // not expected, the expression compile
+		validation.Errors = []Issue{makeIssue(err)}
+		return validation
+	}
+	if schema == nil {
+		return validation
+	}
+
+	for _, name := range referencedFields(tree.Node) {
+		if _, ok := schema[name]; !ok {
+			validation.UnknownFields = append(validation.UnknownFields, name)
+		}
+	}
+
+	config := conf.CreateNew()
+	config.WithEnv(schema)
+	config.Strict = false // unknown fields are already reported
+	resultType, err := checker.Check(tree, config)
+	if err != nil {
+		validation.Warnings = append(validation.Warnings, makeIssue(err))
+		return validation
+	}
+	if resultType != nil && resultType.Kind() != reflect.Interface {
+		validation.ResultType = resultType.String()
+		if resultType.Kind() != reflect.Bool {
+			validation.Warnings = append(validation.Warnings, Issue{Message: notBoolMsg})
+		}
+	}
+	return validation
+}
+
+func makeIssue(err error) Issue {
+	var fileErr *file.Error
+	if errors.As(err, &fileErr) && !fileErr.Location.Empty() {
+		return Issue{Message: fileErr.Message, Line: fileErr.Line, Column: fileErr.Column + 1}
+	}
+	return Issue{Message: err.Error()}
+}
+
+// sorted names of the variables read from the environment
+func referencedFields(node ast.Node) []string {
+	collector := identifierCollector{names: map[string]struct{}{}, declared: map[string]struct{}{}}
+	ast.Walk(&node, &collector)
+
+	builtins := conf.CreateNew().Builtins
+	fields := make([]string, 0, len(collector.names))
+	for name := range collector.names {
+		_, isBuiltin := builtins[name]
+		_, isDeclared := collector.declared[name]
+		if !isBuiltin && !isDeclared && name != "$env" {
+			fields = append(fields, name)
+		}
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+type identifierCollector struct {
+	names    map[string]struct{}
+	declared map[string]struct{} // by let
+}
+
+func (c *identifierCollector) Visit(node *ast.Node) {
+	switch casted := (*node).(type) {
+	case *ast.IdentifierNode:
+		c.names[casted.Value] = struct{}{}
+	case *ast.VariableDeclaratorNode:
+		c.declared[casted.Name] = struct{}{}
+	}
+}
+
+// sub-expressions depending on a closure or a let variable are not evaluated alone
+func NewExplainer(expression string) (*Explainer, error) {
+	tree, err := parser.Parse(expression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = expr.Compile(expression); err != nil {
+		return nil, err
+	}
+
+	// declarations are visited after their uses
+	identifiers := identifierCollector{names: map[string]struct{}{}, declared: map[string]struct{}{}}
+	ast.Walk(&tree.Node, &identifiers)
+	collector := stepCollector{seen: map[string]struct{}{}, declared: identifiers.declared, builtins: conf.CreateNew().Builtins}
+	ast.Walk(&tree.Node, &collector)
+	steps := make([]explainStep, 0, len(collector.expressions))
+	for _, subExpression := range collector.expressions {
+		program, err := expr.Compile(subExpression)
+		if err != nil {
+			continue // the printed form of a node is not always a valid expression
+		}
+		steps = append(steps, explainStep{expression: subExpression, program: program})
+	}
+	// the whole expression is always evaluated last
+	if length := len(steps); length == 0 || steps[length-1].expression != tree.Node.String() {
+		program, _ := expr.Compile(expression)
+		steps = append(steps, explainStep{expression: expression, program: program})
+	}
+	return &Explainer{steps: steps}, nil
+}
+
+// the boolean is the result of the predicate (false when the whole expression fails or is not a boolean)
+func (e *Explainer) Explain(value any) (bool, []Evaluation) {
+	evaluations := make([]Evaluation, 0, len(e.steps))
+	for _, step := range e.steps {
+		output, err := expr.Run(step.program, value)
+		evaluations = append(evaluations, Evaluation{Expression: step.expression, Value: output, Err: err})
+	}
+	last := evaluations[len(evaluations)-1]
+	match, _ := last.Value.(bool)
+	return match && last.Err == nil, evaluations
+}
+
+type stepCollector struct {
+	expressions []string
+	seen        map[string]struct{}
+	declared    map[string]struct{} // by let
+	builtins    map[string]*ast.Function
+}
+
+func (c *stepCollector) Visit(node *ast.Node) {
+	switch casted := (*node).(type) {
+	case *ast.NilNode, *ast.IntegerNode, *ast.FloatNode, *ast.BoolNode, *ast.StringNode, *ast.ConstantNode, *ast.PointerNode, *ast.ClosureNode:
+		return // literals and closure parts are not worth explaining
+	case *ast.IdentifierNode:
+		if _, ok := c.builtins[casted.Value]; ok {
+			return // a function is not a value
+		}
+	}
+
+	if !c.standalone(*node) {
+		return
+	}
+	subExpression := (*node).String()
+	if _, ok := c.seen[subExpression]; !ok {
+		c.seen[subExpression] = struct{}{}
+		c.expressions = append(c.expressions, subExpression)
+	}
+}
+
+// false when the node read a closure argument or a let variable
+func (c *stepCollector) standalone(node ast.Node) bool {
+	checker := standaloneChecker{declared: c.declared, standalone: true}
+	ast.Walk(&node, &checker)
+	return checker.standalone
+}
+
+type standaloneChecker struct {
+	declared   map[string]struct{}
+	standalone bool
+}
+
+func (c *standaloneChecker) Visit(node *ast.Node) {
+	switch casted := (*node).(type) {
+	case *ast.PointerNode:
+		c.standalone = false
+	case *ast.IdentifierNode:
+		if _, ok := c.declared[casted.Value]; ok {
+			c.standalone = false
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package predicate
+
+import (
+	"reflect"
+	"testing"
+)
+
+var testSchema = map[string]any{
+	"name": "api", "owner": "acme", "license": nil, "forks_count": 1.0, "watchers_count": 2.0,
+	"topics": []any{"go"}, "languages": map[string]any{"Go": 100.0},
+}
+
+func TestValidate(t *testing.T) {
+	testCases := []struct {
+		expression    string
+		schema        map[string]any
+		errors        []Issue
+		warnings      []Issue
+		resultType    string
+		unknownFields []string
+	}{
+		{expression: "forks_count > 1", schema: testSchema, resultType: "bool"},
+		// without schema, only the syntax is checked
+		{expression: "forks_count + 1", resultType: "unknown"},
+		{expression: "forks_count >", schema: testSchema, resultType: "unknown", errors: []Issue{{Message: "unexpected token EOF", Line: 1, Column: 13}}},
+		{expression: "forks_count > 1 and\n  )", resultType: "unknown", errors: []Issue{{Message: `unexpected token Bracket(")")`, Line: 2, Column: 3}}},
+		{expression: "forks_count + 1", schema: testSchema, resultType: "float64", warnings: []Issue{{Message: notBoolMsg}}},
+		{expression: "upper(name)", schema: testSchema, resultType: "string", warnings: []Issue{{Message: notBoolMsg}}},
+		{
+			expression: "forks_count > 'a'", schema: testSchema, resultType: "unknown",
+			warnings: []Issue{{Message: "invalid operation: > (mismatched types float64 and string)", Line: 1, Column: 13}},
+		},
+		// builtins, let variables and closure arguments are not fields
+		{expression: "name == 'x' and starz > 1 or foo", schema: testSchema, resultType: "bool", unknownFields: []string{"foo", "starz"}},
+		{expression: "let x = forks_count; x > 1 and len(topics) > 0", schema: testSchema, resultType: "bool"},
+		{expression: "any(topics, # == 'go') and $env.owner == 'acme'", schema: testSchema, resultType: "bool"},
+	}
+	for _, testCase := range testCases {
+		validation := Validate(testCase.expression, testCase.schema)
+		if !reflect.DeepEqual(validation.Errors, testCase.errors) || !reflect.DeepEqual(validation.Warnings, testCase.warnings) {
+			t.Errorf("%q : expected errors %v and warnings %v, got %v and %v", testCase.expression, testCase.errors, testCase.warnings, validation.Errors, validation.Warnings)
+		}
+		if validation.ResultType != testCase.resultType {
+			t.Errorf("%q : expected the result type %q, got %q", testCase.expression, testCase.resultType, validation.ResultType)
+		}
+		if !reflect.DeepEqual(validation.UnknownFields, testCase.unknownFields) {
+			t.Errorf("%q : expected the unknown fields %v, got %v", testCase.expression, testCase.unknownFields, validation.UnknownFields)
+		}
+	}
+}
+
+func TestExplain(t *testing.T) {
+	testCases := []struct {
+		expression string
+		steps      []string
+		match      bool
+	}{
+		{expression: "len(topics) > 1", steps: []string{"topics", "len(topics)", "len(topics) > 1"}},
+		// repeated sub-expressions are evaluated once, literals are skipped
+		{expression: "forks_count > 0 and forks_count < 10", steps: []string{"forks_count", "forks_count > 0", "forks_count < 10", "forks_count > 0 and forks_count < 10"}, match: true},
+		// the closure parts depend on the element
+		{
+			expression: "any(topics, {# == 'go'}) and forks_count > 1",
+			steps:      []string{"topics", "forks_count", "forks_count > 1", "any(topics, {# == 'go'}) and forks_count > 1"},
+		},
+		// the let variable is not evaluated alone
+		{expression: "let x = watchers_count; x > 1", steps: []string{"watchers_count", "let x = watchers_count; x > 1"}, match: true},
+		// the whole expression is evaluated last, even when it is a single field
+		{expression: "license == nil", steps: []string{"license", "license == nil"}, match: true},
+		{expression: "name", steps: []string{"name"}},
+	}
+	for _, testCase := range testCases {
+		explainer, err := NewExplainer(testCase.expression)
+		if err != nil {
+			t.Errorf("%q : unexpected error : %v", testCase.expression, err)
+			continue
+		}
+
+		match, evaluations := explainer.Explain(testSchema)
+		steps := make([]string, 0, len(evaluations))
+		for _, evaluation := range evaluations {
+			steps = append(steps, evaluation.Expression)
+		}
+		if !reflect.DeepEqual(steps, testCase.steps) {
+			t.Errorf("%q : expected the steps %q, got %q", testCase.expression, testCase.steps, steps)
+		}
+		if match != testCase.match {
+			t.Errorf("%q : expected match %v, got %v", testCase.expression, testCase.match, match)
+		}
+	}
+}
+
+func TestExplainReportsErrors(t *testing.T) {
+	if _, err := NewExplainer("forks_count >"); err == nil {
+		t.Fatal("expected a compilation error")
+	}
+
+	explainer, err := NewExplainer("starz > 1 or forks_count == 1")
+	if err != nil {
+		t.Fatalf("unexpected error : %v", err)
+	}
+	match, evaluations := explainer.Explain(testSchema)
+	if match {
+		t.Error("expected no match, the whole expression fails")
+	}
+	failed := map[string]bool{}
+	for _, evaluation := range evaluations {
+		failed[evaluation.Expression] = evaluation.Err != nil
+	}
+	expected := map[string]bool{"starz": false, "starz > 1": true, "forks_count": false, "forks_count == 1": false, "starz > 1 or forks_count == 1": true}
+	if !reflect.DeepEqual(failed, expected) {
+		t.Errorf("expected failures %v, got %v", expected, failed)
+	}
+}
@@ -0,0 +1,75 @@
+package predicate
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// simple query parameters, with their translation as a format taking the (quoted) value
+var (
+	// a repository match if it match one of the values
+	textParams = []struct {
+		name   string
+		format string
+		lower  bool
+	}{
+		{name: "language", format: "%s in languages"},
+		{name: "topic", format: "%s in topics", lower: true}, // topics are always lower case
+		{name: "license", format: "license == %s", lower: true},
+		{name: "owner", format: "owner == %s"},
+	}
+	// a repository match if it match all the values
+	numberParams = []struct {
+		name   string
+		format string
+	}{
+		{name: "min_watchers", format: "watchers_count >= %d"},
+		{name: "max_watchers", format: "watchers_count <= %d"},
+		{name: "min_forks", format: "forks_count >= %d"},
+		{name: "max_forks", format: "forks_count <= %d"},
+	}
+)
+
+type QueryError struct {
+	Parameter string
+	Value     string
+}
+
+func (e QueryError) Error() string {
+	return "invalid " + e.Parameter + " value " + strconv.Quote(e.Value) + ", an integer is expected"
+}
+
+// translate simple query parameters (like language=Go&min_watchers=100) into an expression,
+// other parameters are ignored and the expression is empty when there is no simple parameter
+func TranslateQuery(query map[string][]string) (string, error) {
+	var parts []string
+	for _, param := range textParams {
+		values := query[param.name]
+		alternatives := make([]string, 0, len(values))
+		for _, value := range values {
+			if param.lower {
+				value = strings.ToLower(value)
+			}
+			alternatives = append(alternatives, fmt.Sprintf(param.format, strconv.Quote(value)))
+		}
+		switch len(alternatives) {
+		case 0:
+		case 1:
+			parts = append(parts, alternatives[0])
+		default:
+			parts = append(parts, "("+strings.Join(alternatives, " or ")+")")
+		}
+	}
+
+	for _, param := range numberParams {
+		for _, value := range query[param.name] {
+			number, err := strconv.Atoi(value)
+			if err != nil {
+				return "", QueryError{Parameter: param.name, Value: value}
+			}
+			parts = append(parts, fmt.Sprintf(param.format, number))
+		}
+	}
+	return strings.Join(parts, " and "), nil
+}
@@ -0,0 +1,94 @@
+package predicate
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestTranslateQuery(t *testing.T) {
+	testCases := []struct {
+		query    string
+		expected string
+	}{
+		{query: "", expected: ""},
+		{query: "sort=stars&filter=true", expected: ""}, // not simple parameters
+		{query: "language=Go", expected: `"Go" in languages`},
+		// repeated values match any of them
+		{query: "language=Go&language=Rust", expected: `("Go" in languages or "Rust" in languages)`},
+		{query: "owner=foo&owner=bar&owner=baz", expected: `(owner == "foo" or owner == "bar" or owner == "baz")`},
+		// topics and license keys are lower case
+		{query: "topic=Kubernetes&license=MIT", expected: `"kubernetes" in topics and license == "mit"`},
+		{query: "owner=FooBar", expected: `owner == "FooBar"`},
+		// values are quoted
+		{query: `owner=a"b%5Cc`, expected: `owner == "a\"b\\c"`},
+		{query: "language=C%2B%2B&language=Objective-C", expected: `("C++" in languages or "Objective-C" in languages)`},
+		// numbers must all match
+		{query: "min_watchers=100&max_watchers=200&min_forks=1&max_forks=2", expected: "watchers_count >= 100 and watchers_count <= 200 and forks_count >= 1 and forks_count <= 2"},
+		{query: "min_forks=1&min_forks=3", expected: "forks_count >= 1 and forks_count >= 3"},
+		{
+			query:    "min_watchers=100&topic=go&language=Go&license=mit&owner=foo",
+			expected: `"Go" in languages and "go" in topics and license == "mit" and owner == "foo" and watchers_count >= 100`,
+		},
+	}
+	for _, testCase := range testCases {
+		query, err := url.ParseQuery(testCase.query)
+		if err != nil {
+			t.Fatalf("%q : unexpected error : %v", testCase.query, err)
+		}
+		translated, err := TranslateQuery(query)
+		if err != nil {
+			t.Errorf("%q : unexpected error : %v", testCase.query, err)
+			continue
+		}
+		if translated != testCase.expected {
+			t.Errorf("%q : expected %s, got %s", testCase.query, testCase.expected, translated)
+			continue
+		}
+		if translated == "" {
+			continue
+		}
+		if _, err = ParsePredicate(translated); err != nil {
+			t.Errorf("%q : translation does not compile : %v", testCase.query, err)
+		}
+	}
+}
+
+func TestTranslateQueryQuotedValueMatch(t *testing.T) {
+	translated, _ := TranslateQuery(url.Values{"owner": {`a"b\c`}, "language": {"C++"}})
+	predicate, err := ParsePredicate(translated)
+	if err != nil {
+		t.Fatalf("unexpected error : %v", err)
+	}
+	if !predicate(map[string]any{"owner": `a"b\c`, "languages": map[string]any{"C++": 10.0}}) {
+		t.Errorf("%s : expected a match", translated)
+	}
+	if predicate(map[string]any{"owner": "abc", "languages": map[string]any{"C++": 10.0}}) {
+		t.Errorf("%s : unexpected match", translated)
+	}
+}
+
+func TestTranslateQueryRejectsInvalidNumber(t *testing.T) {
+	testCases := []struct {
+		query     string
+		parameter string
+		value     string
+	}{
+		{query: "min_watchers=abc", parameter: "min_watchers", value: "abc"},
+		{query: "language=Go&max_forks=1.5", parameter: "max_forks", value: "1.5"},
+		{query: "min_forks=1&min_forks=", parameter: "min_forks", value: ""},
+	}
+	for _, testCase := range testCases {
+		query, _ := url.ParseQuery(testCase.query)
+		translated, err := TranslateQuery(query)
+		var queryErr QueryError
+		if !errors.As(err, &queryErr) {
+			t.Errorf("%q : expected a QueryError, got %v", testCase.query, err)
+			continue
+		}
+		if queryErr.Parameter != testCase.parameter || queryErr.Value != testCase.value || translated != "" {
+			t.Errorf("%q : unexpected error %+v (translated to %q)", testCase.query, queryErr, translated)
+		}
+	}
+}
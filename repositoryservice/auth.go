@@ -0,0 +1,227 @@
+package repositoryservice
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/dvaumoron/sclng-backend-test-v1/limitedconcurrent"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	NoAuth    = "none"
+	TokenAuth = "token"
+	AppAuth   = "app"
+
+	jwtDuration = 9 * time.Minute // GitHub refuse JWT valid more than 10 minutes
+	renewMargin = 5 * time.Minute // installation tokens are renewed before their expiration
+)
+
+var jwtHeader = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256","typ":"JWT"}`))
+
+type Authentication struct {
+	mode           string
+	tokens         []string
+	appId          string
+	installationId string
+	privateKey     *rsa.PrivateKey
+}
+
+// provide the Authorization header of calls
+type credential interface {
+	// an empty header means an unauthenticated call
+	authorization(ctx context.Context) (string, error)
+	// forget a refused authorization, return false when it can not be renewed
+	invalidate() bool
+}
+
+type staticCredential string
+
+type appCredential struct {
+	log        logrus.FieldLogger
+	httpClient *http.Client
+	scheduler  limitedconcurrent.Scheduler
+	headers    map[string]string
+	tokenUrl   string
+	appId      string
+	privateKey *rsa.PrivateKey
+	mutex      sync.Mutex
+	token      string
+	expiresAt  time.Time
+}
+
+// privateKeyPem is only used with AppAuth (PKCS#1 or PKCS#8 RSA key, as generated by GitHub)
+func MakeAuthentication(mode string, tokens []string, appId string, installationId string, privateKeyPem []byte) (Authentication, error) {
+	switch mode {
+	case NoAuth:
+		return Authentication{mode: mode}, nil
+	case TokenAuth:
+		if len(tokens) == 0 {
+			return Authentication{}, errors.New("token authentication need at least one access token")
+		}
+		return Authentication{mode: mode, tokens: tokens}, nil
+	case AppAuth:
+		if appId == "" || installationId == "" {
+			return Authentication{}, errors.New("app authentication need an app id and an installation id")
+		}
+		privateKey, err := parsePrivateKey(privateKeyPem)
+		if err != nil {
+			return Authentication{}, err
+		}
+		return Authentication{mode: mode, appId: appId, installationId: installationId, privateKey: privateKey}, nil
+	}
+	return Authentication{}, errors.Errorf("unknown authentication mode %q", mode)
+}
+
+func parsePrivateKey(privateKeyPem []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(privateKeyPem)
+	if block == nil {
+		return nil, errors.New("no PEM block in app private key")
+	}
+
+	if privateKey, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return privateKey, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, errors.Wrap(err, "fail to parse app private key")
+	}
+	privateKey, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("app private key is not a RSA key")
+	}
+	return privateKey, nil
+}
+
+func (a Authentication) Authenticated() bool {
+	return a.mode != NoAuth
+}
+
+func (a Authentication) credentials(log logrus.FieldLogger, httpClient *http.Client, scheduler limitedconcurrent.Scheduler, headers map[string]string, apiUrl string) []credential {
+	switch a.mode {
+	case NoAuth:
+		return []credential{staticCredential("")}
+	case AppAuth:
+		return []credential{&appCredential{
+			log: log, httpClient: httpClient, scheduler: scheduler, headers: headers, tokenUrl: apiUrl + "/app/installations/" + a.installationId + "/access_tokens",
+			appId: a.appId, privateKey: a.privateKey,
+		}}
+	}
+
+	credentials := make([]credential, 0, len(a.tokens))
+	for _, token := range a.tokens {
+		credentials = append(credentials, staticCredential("Bearer "+token))
+	}
+	return credentials
+}
+
+func (c staticCredential) authorization(ctx context.Context) (string, error) {
+	return string(c), nil
+}
+
+func (c staticCredential) invalidate() bool {
+	return false
+}
+
+// return the installation token, a new one is requested when the current one is near its expiration
+func (c *appCredential) authorization(ctx context.Context) (string, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.token != "" && time.Until(c.expiresAt) > renewMargin {
+		return c.token, nil
+	}
+
+	token, expiresAt, err := c.requestInstallationToken(ctx)
+	if err != nil {
+		return "", err
+	}
+	c.token, c.expiresAt = "Bearer "+token, expiresAt
+	c.log.WithField("expires_at", expiresAt).Info("Installation token renewed")
+	return c.token, nil
+}
+
+func (c *appCredential) invalidate() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.token = ""
+	return true
+}
+
+func (c *appCredential) requestInstallationToken(ctx context.Context) (string, time.Time, error) {
+	jwt, err := c.signJwt(time.Now())
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	// usually nested in the scheduled call which need the token
+	ctx, release, err := c.scheduler.Acquire(ctx)
+	if err != nil {
+		return "", time.Time{}, errors.Wrap(err, "fail to schedule installation token request")
+	}
+	defer release()
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, c.tokenUrl, nil)
+	if err != nil {
+		return "", time.Time{}, errors.Wrap(err, "fail to create installation token request")
+	}
+	for name, value := range c.headers {
+		request.Header.Set(name, value)
+	}
+	request.Header.Set("Authorization", "Bearer "+jwt)
+
+	response, err := c.httpClient.Do(request)
+	if err != nil {
+		return "", time.Time{}, errors.Wrap(err, "fail during installation token request")
+	}
+	defer response.Body.Close()
+
+	data, err := io.ReadAll(response.Body)
+	if err != nil {
+		return "", time.Time{}, errors.Wrap(err, "fail to read installation token response")
+	}
+	if response.StatusCode != http.StatusCreated {
+		return "", time.Time{}, errors.Errorf("unexpected installation token response status %d", response.StatusCode)
+	}
+
+	var parsed struct {
+		Token     string    `json:"token"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+	if err = json.Unmarshal(data, &parsed); err != nil {
+		return "", time.Time{}, errors.Wrap(err, "fail to parse installation token response")
+	}
+	return parsed.Token, parsed.ExpiresAt, nil
+}
+
+// build a RS256 JSON Web Token identifying the app
+func (c *appCredential) signJwt(now time.Time) (string, error) {
+	// issued in the past to allow clock drift
+	claims, err := json.Marshal(map[string]any{
+		"iat": now.Add(-time.Minute).Unix(), "exp": now.Add(jwtDuration).Unix(), "iss": c.appId,
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "fail to build JWT claims")
+	}
+
+	signed := jwtHeader + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, c.privateKey, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", errors.Wrap(err, "fail to sign JWT")
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
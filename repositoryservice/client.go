@@ -0,0 +1,141 @@
+package repositoryservice
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+type apiClient struct {
+	log        logrus.FieldLogger
+	httpClient *http.Client
+	headers    map[string]string // forge specific headers and User-Agent
+	tokens     *tokenPool
+	throttle   *throttle // every call is scheduled by throttle.scheduler
+}
+
+func (c apiClient) get(ctx context.Context, callUrl string) ([]byte, http.Header) {
+	return c.request(ctx, http.MethodGet, callUrl, nil)
+}
+
+// log and return nil on any error (including a non 200 status)
+func (c apiClient) request(ctx context.Context, method string, callUrl string, body []byte) ([]byte, http.Header) {
+	status, data, header := c.call(ctx, method, callUrl, body)
+	if status != http.StatusOK {
+		if status != 0 {
+			c.log.WithField("url", callUrl).WithField("status", status).Error("Unexpected api response status")
+		}
+		return nil, nil
+	}
+	return data, header
+}
+
+// return a zero status (after logging) when the call fails, other status are left to the caller,
+// a token refused with a 401 status is renewed once (when its credential allows it) or removed
+// from the pool, then the call is retried (an anonymous token is never removed, its 401 is returned)
+func (c apiClient) call(ctx context.Context, method string, callUrl string, body []byte) (int, []byte, http.Header) {
+	renewed := false
+	for {
+		token := c.tokens.pick()
+		if token == nil {
+			c.log.WithField("url", callUrl).Error("No valid access token left")
+			return 0, nil, nil
+		}
+
+		status, data, header := c.callWithToken(ctx, method, callUrl, body, token)
+		if status != http.StatusUnauthorized || renewed || token.anonymous() {
+			return status, data, header
+		}
+
+		tokenRenewed, revoked := c.tokens.refuse(token)
+		renewed = tokenRenewed
+		if revoked {
+			c.log.WithField("valid_tokens", c.tokens.validCount()).Error("Access token refused, it is removed")
+		}
+	}
+}
+
+func (c apiClient) callWithToken(ctx context.Context, method string, callUrl string, body []byte, token *accessToken) (int, []byte, http.Header) {
+	// nested calls (like the renewal of an installation token) borrow the slot of this one
+	ctx, release, err := c.throttle.scheduler.Acquire(ctx)
+	if err != nil {
+		c.log.WithError(err).Error("Fail to schedule api request")
+		return 0, nil, nil
+	}
+	defer release()
+
+	var bodyReader io.Reader
+	if body != nil {
+		bodyReader = bytes.NewReader(body)
+	}
+
+	request, err := http.NewRequestWithContext(ctx, method, callUrl, bodyReader)
+	if err != nil {
+		c.log.WithError(err).Error("Fail to create api request")
+		return 0, nil, nil
+	}
+	for name, value := range c.headers {
+		request.Header.Set(name, value)
+	}
+	authorization, err := token.credential.authorization(ctx)
+	if err != nil {
+		c.log.WithError(err).Error("Fail to get api authorization")
+		return 0, nil, nil
+	}
+	if authorization != "" {
+		request.Header.Set("Authorization", authorization)
+	}
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+
+	response, err := c.httpClient.Do(request)
+	if err != nil {
+		c.log.WithError(err).Error("Fail during api request")
+		return 0, nil, nil
+	}
+	defer response.Body.Close()
+
+	token.rateLimit.update(response.Header)
+	c.throttle.adjust(c.tokens)
+
+	data, err := io.ReadAll(response.Body)
+	if err != nil {
+		c.log.WithError(err).Error("Fail to read api response")
+		return 0, nil, nil
+	}
+	return response.StatusCode, data, response.Header
+}
+
+// extract the url with the wanted relation from a Link header
+// (like `<https://api.github.com/events?page=2>; rel="next", <https://api.github.com/events?page=3>; rel="last"`)
+func linkUrl(linkHeader string, rel string) string {
+	relParam := "rel=\"" + rel + "\""
+	for _, link := range strings.Split(linkHeader, ",") {
+		parts := strings.Split(link, ";")
+		for _, param := range parts[1:] {
+			if strings.TrimSpace(param) == relParam {
+				return strings.Trim(strings.TrimSpace(parts[0]), "<>")
+			}
+		}
+	}
+	return ""
+}
+
+func addQueryParam(baseUrl string, name string, value string) string {
+	var urlBuilder strings.Builder
+	urlBuilder.WriteString(baseUrl)
+	if strings.Contains(baseUrl, "?") {
+		urlBuilder.WriteByte('&')
+	} else {
+		urlBuilder.WriteByte('?')
+	}
+	urlBuilder.WriteString(name)
+	urlBuilder.WriteByte('=')
+	urlBuilder.WriteString(value)
+	return urlBuilder.String()
+}
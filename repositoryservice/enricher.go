@@ -0,0 +1,302 @@
+package repositoryservice
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dvaumoron/sclng-backend-test-v1/limitedconcurrent"
+	"github.com/pkg/errors"
+)
+
+const (
+	ReleaseEnricher        = "release"
+	ContributorsEnricher   = "contributors"
+	ReadmeEnricher         = "readme"
+	IssuesEnricher         = "issues"
+	CommitActivityEnricher = "commit_activity"
+)
+
+// add a field to the cleaned repositories, fetch return false when the value can not be retrieved (and must not be cached)
+type Enricher struct {
+	name  string
+	field string
+	ttl   time.Duration
+	fetch func(r retriever, ctx context.Context, repoPath string) (any, bool)
+}
+
+// those data change less often than the event stream, so they are cached between refreshes
+var defaultEnrichers = map[string]Enricher{
+	ReleaseEnricher:        {field: "latest_release", ttl: 6 * time.Hour, fetch: retriever.fetchLatestRelease},
+	ContributorsEnricher:   {field: "contributors_count", ttl: 24 * time.Hour, fetch: retriever.fetchContributorsCount},
+	ReadmeEnricher:         {field: "has_readme", ttl: 24 * time.Hour, fetch: retriever.fetchReadmePresence},
+	IssuesEnricher:         {field: "open_counts", ttl: time.Hour, fetch: retriever.fetchOpenCounts},
+	CommitActivityEnricher: {field: "commit_activity", ttl: 24 * time.Hour, fetch: retriever.fetchCommitActivity},
+}
+
+type enricherCacheEntry struct {
+	value     any
+	expiresAt time.Time
+}
+
+type enricherCache struct {
+	mutex   sync.Mutex
+	entries map[string]enricherCacheEntry
+}
+
+type enrichment struct {
+	index int
+	field string
+	value any
+	ok    bool
+}
+
+// ttls override the default ones by enricher name
+func MakeEnrichers(names []string, ttls map[string]time.Duration) ([]Enricher, error) {
+	enrichers := make([]Enricher, 0, len(names))
+	for _, name := range names {
+		e, ok := defaultEnrichers[name]
+		if !ok {
+			return nil, errors.Errorf("unknown enricher %q", name)
+		}
+		e.name = name
+		if ttl, ok := ttls[name]; ok {
+			e.ttl = ttl
+		}
+		enrichers = append(enrichers, e)
+	}
+	return enrichers, nil
+}
+
+func newEnricherCache() *enricherCache {
+	return &enricherCache{entries: map[string]enricherCacheEntry{}}
+}
+
+func (c *enricherCache) get(key string, now time.Time) (any, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok || now.After(entry.expiresAt) {
+		return nil, false
+	}
+	return entry.value, true
+}
+
+func (c *enricherCache) set(key string, value any, expiresAt time.Time) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.entries[key] = enricherCacheEntry{value: value, expiresAt: expiresAt}
+}
+
+// avoid unbounded growth when repositories leave the event stream
+func (c *enricherCache) purge(now time.Time) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for key, entry := range c.entries {
+		if now.After(entry.expiresAt) {
+			delete(c.entries, key)
+		}
+	}
+}
+
+// repositories are modified in place (they are not published yet), return error and skip counts
+func (r retriever) enrichRepositories(ctx context.Context, repositories []JsonObject) (int, int) {
+	if len(r.enrichers) == 0 {
+		return 0, 0
+	}
+
+	now := time.Now()
+	r.enricherCache.purge(now)
+
+	skipCount := 0
+	senders := make([]func(chan<- enrichment), 0, len(repositories)*len(r.enrichers))
+	for index, repository := range repositories {
+		fullName, _ := repository["full_name"].(string)
+		if fullName == "" {
+			continue
+		}
+
+		for _, e := range r.enrichers {
+			cacheKey := e.name + ":" + fullName
+			if value, ok := r.enricherCache.get(cacheKey, now); ok {
+				repository[e.field] = value
+				continue
+			}
+
+			if r.client.tokens.under(r.minRateRemaining) {
+				skipCount++
+				continue
+			}
+
+			indexCopy, fullNameCopy, enricherCopy := index, fullName, e // avoid closure capture
+			senders = append(senders, func(enrichmentChan chan<- enrichment) {
+				// check again, the rate limit decrease during the enrichment
+				if ctx.Err() != nil || r.client.tokens.under(r.minRateRemaining) {
+					enrichmentChan <- enrichment{index: -1}
+					return
+				}
+
+				value, ok := enricherCopy.fetch(r, ctx, fullNameCopy)
+				if ok {
+					r.enricherCache.set(cacheKey, value, time.Now().Add(enricherCopy.ttl))
+				}
+				enrichmentChan <- enrichment{index: indexCopy, field: enricherCopy.field, value: value, ok: ok}
+			})
+		}
+	}
+
+	errorCount := 0
+	// launch calls on the shared pool (limited parallelism)
+	for _, result := range limitedconcurrent.LaunchPooled(ctx, r.pool, senders) {
+		switch {
+		case result.index < 0:
+			skipCount++
+		case result.ok:
+			repositories[result.index][result.field] = result.value
+		default:
+			errorCount++
+		}
+	}
+	return errorCount, skipCount
+}
+
+func (r retriever) repoApiUrl(repoPath string, suffix string) string {
+	var urlBuilder strings.Builder
+	urlBuilder.WriteString(r.apiUrl)
+	urlBuilder.WriteString("/repos/")
+	urlBuilder.WriteString(repoPath)
+	urlBuilder.WriteString(suffix)
+	return urlBuilder.String()
+}
+
+// a nil value means no release
+func (r retriever) fetchLatestRelease(ctx context.Context, repoPath string) (any, bool) {
+	status, data, _ := r.client.call(ctx, http.MethodGet, r.repoApiUrl(repoPath, "/releases/latest"), nil)
+	switch status {
+	case http.StatusNotFound:
+		return nil, true
+	case http.StatusOK:
+	default:
+		return nil, false
+	}
+
+	var release struct {
+		TagName     string `json:"tag_name"`
+		PublishedAt string `json:"published_at"`
+	}
+	if err := json.Unmarshal(data, &release); err != nil {
+		r.log.WithError(err).Error("Fail to parse release api response")
+		return nil, false
+	}
+	return JsonObject{"tag": release.TagName, "published_at": release.PublishedAt}, true
+}
+
+func (r retriever) fetchContributorsCount(ctx context.Context, repoPath string) (any, bool) {
+	count, ok := r.countItems(ctx, r.repoApiUrl(repoPath, "/contributors?anon=1&per_page=1"))
+	return float64(count), ok
+}
+
+func (r retriever) fetchReadmePresence(ctx context.Context, repoPath string) (any, bool) {
+	switch status, _, _ := r.client.call(ctx, http.MethodGet, r.repoApiUrl(repoPath, "/readme"), nil); status {
+	case http.StatusOK:
+		return true, true
+	case http.StatusNotFound:
+		return false, true
+	}
+	return nil, false
+}
+
+// open_issues_count of the repository includes pull requests, it is read again rather than kept from the
+// repository retrieval (not in the cleaned schema, and absent with the graphql backend), so a cache miss costs
+// two calls, the counts are not read at the same moment and the issues count is clamped at 0
+func (r retriever) fetchOpenCounts(ctx context.Context, repoPath string) (any, bool) {
+	data, _ := r.client.get(ctx, r.repoApiUrl(repoPath, ""))
+	if len(data) == 0 {
+		return nil, false
+	}
+
+	var repository struct {
+		OpenIssuesCount int `json:"open_issues_count"`
+	}
+	if err := json.Unmarshal(data, &repository); err != nil {
+		r.log.WithError(err).Error("Fail to parse repository api response")
+		return nil, false
+	}
+
+	pullCount, ok := r.countItems(ctx, r.repoApiUrl(repoPath, "/pulls?state=open&per_page=1"))
+	if !ok {
+		return nil, false
+	}
+	issueCount := repository.OpenIssuesCount - pullCount
+	if issueCount < 0 {
+		issueCount = 0
+	}
+	return JsonObject{"issues": float64(issueCount), "pull_requests": float64(pullCount)}, true
+}
+
+// weekly commit counts of the last year (oldest first)
+func (r retriever) fetchCommitActivity(ctx context.Context, repoPath string) (any, bool) {
+	status, data, _ := r.client.call(ctx, http.MethodGet, r.repoApiUrl(repoPath, "/stats/commit_activity"), nil)
+	switch status {
+	case http.StatusNoContent:
+		return JsonObject{"weekly": []any{}, "total": float64(0)}, true
+	case http.StatusOK:
+	default:
+		// 202 Accepted when GitHub is computing the statistics, retry at next refresh
+		return nil, false
+	}
+
+	var weeks []struct {
+		Total int `json:"total"`
+	}
+	if err := json.Unmarshal(data, &weeks); err != nil {
+		r.log.WithError(err).Error("Fail to parse commit activity api response")
+		return nil, false
+	}
+
+	total := 0
+	weekly := make([]any, 0, len(weeks))
+	for _, week := range weeks {
+		total += week.Total
+		weekly = append(weekly, float64(week.Total))
+	}
+	return JsonObject{"weekly": weekly, "total": float64(total)}, true
+}
+
+// with per_page=1, the page number of the last link is the number of items
+func (r retriever) countItems(ctx context.Context, listUrl string) (int, bool) {
+	status, data, header := r.client.call(ctx, http.MethodGet, listUrl, nil)
+	switch status {
+	case http.StatusNoContent: // empty repository
+		return 0, true
+	case http.StatusOK:
+	default:
+		return 0, false
+	}
+
+	if lastUrl := linkUrl(header.Get("Link"), "last"); lastUrl != "" {
+		parsed, err := url.Parse(lastUrl)
+		if err == nil {
+			if page, err := strconv.Atoi(parsed.Query().Get("page")); err == nil {
+				return page, true
+			}
+		}
+		r.log.WithField("url", lastUrl).Error("Fail to read page number of last link")
+		return 0, false
+	}
+
+	var items []any
+	if err := json.Unmarshal(data, &items); err != nil {
+		r.log.WithError(err).Error("Fail to parse list api response")
+		return 0, false
+	}
+	return len(items), true
+}
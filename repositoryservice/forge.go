@@ -0,0 +1,70 @@
+package repositoryservice
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	GithubForge = "github"
+	GitlabForge = "gitlab"
+	GiteaForge  = "gitea"
+
+	// name, full_name, description, forks_count, watchers_count, topics, owner, license, organization, languages, source and events
+	cleanedSize = 12
+)
+
+// hide the API contract of a forge, repositories are returned with the cleaned schema of GitHub
+// (name, full_name, description, forks_count, watchers_count, topics, owner, license and organization)
+type Forge interface {
+	FirstPageUrl(sourceUrl string, pageSize int) string
+	// return the activities of the page, the url of the next page (empty when there is none) and false on failure
+	ListActivity(ctx context.Context, pageUrl string) ([]Activity, string, bool)
+	FetchRepository(ctx context.Context, ref RepositoryRef) (JsonObject, bool)
+	FetchLanguages(ctx context.Context, ref RepositoryRef) (JsonObject, bool)
+}
+
+type RepositoryRef struct {
+	Url      string // API url, used as identifier
+	FullName string // "owner/name" when known from the activity
+}
+
+// event types are mapped to the GitHub ones when there is an equivalent
+type Activity struct {
+	Id         string
+	Type       string
+	Actor      string
+	CreatedAt  time.Time
+	Repository RepositoryRef
+}
+
+var forgeHeaders = map[string]map[string]string{
+	GithubForge: {"Accept": "application/vnd.github+json", "X-GitHub-Api-Version": "2022-11-28"},
+	GitlabForge: {"Accept": "application/json"},
+	GiteaForge:  {"Accept": "application/json"},
+}
+
+func ValidateForge(kind string) error {
+	if _, ok := forgeHeaders[kind]; !ok {
+		return errors.Errorf("unknown forge %q", kind)
+	}
+	return nil
+}
+
+// apiUrl is the base url of the forge API (like "https://gitlab.com/api/v4")
+func newForge(kind string, client apiClient, apiUrl string) Forge {
+	switch kind {
+	case GitlabForge:
+		return gitlabForge{client: client, apiUrl: apiUrl}
+	case GiteaForge:
+		return giteaForge{client: client, apiUrl: apiUrl}
+	}
+	return githubForge{client: client}
+}
+
+func parseTime(value string) time.Time {
+	parsed, _ := time.Parse(time.RFC3339, value) // zero time when missing
+	return parsed
+}
@@ -0,0 +1,222 @@
+package repositoryservice
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/dvaumoron/sclng-backend-test-v1/githubfake"
+)
+
+// minimal fake of a forge api : fixed JSON bodies by request uri,
+// the uris without response are answered with 404 and reported by unexpected
+type forgeFake struct {
+	responses map[string]forgeResponse
+	mutex     sync.Mutex
+	missed    []string
+}
+
+type forgeResponse struct {
+	body string
+	next string // uri of the next page, sent in a Link header
+}
+
+func (f *forgeFake) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	response, ok := f.responses[r.URL.RequestURI()]
+	if !ok {
+		f.mutex.Lock()
+		f.missed = append(f.missed, r.URL.RequestURI())
+		f.mutex.Unlock()
+		http.NotFound(w, r)
+		return
+	}
+
+	if response.next != "" {
+		w.Header().Set("Link", fmt.Sprintf("<http://%s%s>; rel=\"next\"", r.Host, response.next))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(response.body))
+}
+
+func (f *forgeFake) unexpected() []string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.missed
+}
+
+// field names with the type of their value
+func schemaOf(repository JsonObject) map[string]string {
+	schema := make(map[string]string, len(repository))
+	for key, value := range repository {
+		schema[key] = fmt.Sprintf("%T", value)
+	}
+	return schema
+}
+
+func eventTypesOf(repository JsonObject) []string {
+	events, _ := repository["events"].(JsonObject)
+	types, _ := events["types"].(JsonObject)
+	eventTypes := make([]string, 0, len(types))
+	for eventType := range types {
+		eventTypes = append(eventTypes, eventType)
+	}
+	sort.Strings(eventTypes)
+	return eventTypes
+}
+
+// a repository cleaned from githubfake (repo2 has a license and an organization, repo1 only a license)
+func githubRepository(t *testing.T, fullName string) JsonObject {
+	t.Helper()
+	service, _ := startService(t, githubfake.Options{RepositoryCount: 10, EventCount: 10}, 10, 1)
+	repository := indexByName(t, service.List())[fullName]
+	if repository == nil {
+		t.Fatalf("expected %s in the GitHub repositories", fullName)
+	}
+	return repository
+}
+
+func checkSchema(t *testing.T, repository JsonObject, expected JsonObject) {
+	t.Helper()
+	schema, expectedSchema := schemaOf(repository), schemaOf(expected)
+	if fmt.Sprint(schema) != fmt.Sprint(expectedSchema) {
+		t.Errorf("%v : expected the schema %v, got %v", repository["full_name"], expectedSchema, schema)
+	}
+}
+
+func TestGitlabForge(t *testing.T) {
+	fake := &forgeFake{responses: map[string]forgeResponse{
+		"/users/1/events?per_page=10": {body: `[
+			{"id": 1, "action_name": "pushed to", "target_type": null, "project_id": 7, "author": {"username": "alice"}, "created_at": "2024-01-02T10:00:00Z"},
+			{"id": 2, "action_name": "opened", "target_type": "MergeRequest", "project_id": 8, "author": {"username": "bob"}, "created_at": "2024-01-02T09:00:00Z"},
+			{"id": 3, "action_name": "joined", "target_type": null, "project_id": 0, "author": {"username": "bob"}, "created_at": "2024-01-02T08:00:00Z"}
+		]`, next: "/users/1/events?per_page=10&page=2"},
+		"/users/1/events?per_page=10&page=2": {body: `[
+			{"id": 4, "action_name": "commented on", "target_type": "DiffNote", "project_id": 7, "author": {"username": "carol"}, "created_at": "2024-01-01T10:00:00Z"},
+			{"id": 5, "action_name": "opened", "target_type": "Issue", "project_id": 8, "author": {"username": "carol"}, "created_at": "2024-01-01T09:00:00Z"}
+		]`},
+		"/projects/7?license=true": {body: `{
+			"id": 7, "name": "tools", "path_with_namespace": "acme/tools", "description": "group project", "forks_count": 3, "star_count": 12,
+			"topics": ["go", "cli"], "namespace": {"kind": "group", "full_path": "acme"}, "license": {"key": "mit", "name": "MIT License"}
+		}`},
+		"/projects/7/languages": {body: `{"Go": 80.5, "Shell": 19.5}`},
+		"/projects/8?license=true": {body: `{
+			"id": 8, "name": "site", "path_with_namespace": "bob/site", "description": null, "forks_count": 0, "star_count": 1,
+			"topics": [], "namespace": {"kind": "user", "full_path": "bob"}, "owner": {"username": "bob"}, "license": null
+		}`},
+		"/projects/8/languages": {body: `{}`},
+	}}
+	service := serveAndMake(t, fake, "/users/1/events", 10, 10, func(options *Options) {
+		options.Forge = GitlabForge
+	})
+
+	byName := indexByName(t, service.List())
+	if len(byName) != 2 {
+		t.Fatalf("expected 2 repositories, got %v", byName)
+	}
+	if unexpected := fake.unexpected(); len(unexpected) != 0 {
+		t.Errorf("unexpected calls : %v", unexpected)
+	}
+
+	group := byName["acme/tools"]
+	if group["owner"] != "acme" || group["organization"] != "acme" || group["license"] != "mit" || group["watchers_count"] != float64(12) {
+		t.Errorf("unexpected group project : %v", group)
+	}
+	if eventTypes := eventTypesOf(group); fmt.Sprint(eventTypes) != "[IssueCommentEvent PushEvent]" {
+		t.Errorf("unexpected event types : %v", eventTypes)
+	}
+	checkSchema(t, group, githubRepository(t, "owner2/repo2"))
+
+	user := byName["bob/site"]
+	if _, ok := user["organization"]; ok || user["owner"] != "bob" || user["description"] != nil {
+		t.Errorf("unexpected user project : %v", user)
+	}
+	if _, ok := user["license"]; ok {
+		t.Errorf("unexpected license : %v", user["license"])
+	}
+	if eventTypes := eventTypesOf(user); fmt.Sprint(eventTypes) != "[IssuesEvent PullRequestEvent]" {
+		t.Errorf("unexpected event types : %v", eventTypes)
+	}
+}
+
+func TestGitlabEventType(t *testing.T) {
+	testCases := []struct {
+		actionName string
+		targetType string
+		expected   string
+	}{
+		{actionName: "pushed new", expected: "PushEvent"},
+		{actionName: "pushed to", expected: "PushEvent"},
+		{actionName: "accepted", targetType: "MergeRequest", expected: "PullRequestEvent"},
+		{actionName: "closed", targetType: "Issue", expected: "IssuesEvent"},
+		{actionName: "commented on", targetType: "Note", expected: "IssueCommentEvent"},
+		{actionName: "commented on", targetType: "DiscussionNote", expected: "IssueCommentEvent"},
+		{actionName: "joined", expected: "MemberEvent"},
+		{actionName: "left", expected: "MemberEvent"},
+		{actionName: "created", expected: "CreateEvent"},
+		{actionName: "created", targetType: "WikiPage::Meta", expected: "created"},
+		{actionName: "deleted", expected: "deleted"},
+	}
+	for _, testCase := range testCases {
+		if eventType := gitlabEventType(testCase.actionName, testCase.targetType); eventType != testCase.expected {
+			t.Errorf("%q on %q : expected %q, got %q", testCase.actionName, testCase.targetType, testCase.expected, eventType)
+		}
+	}
+}
+
+func TestGiteaForge(t *testing.T) {
+	// Gitea pages are sized with limit (a per_page call would be missed)
+	fake := &forgeFake{responses: map[string]forgeResponse{
+		"/orgs/acme/activities/feeds?limit=10": {body: `[
+			{"id": 1, "op_type": "commit_repo", "act_user": {"login": "alice"}, "repo": {"full_name": "acme/api"}, "created": "2024-01-02T10:00:00Z"},
+			{"id": 2, "op_type": "star_repo", "act_user": {"login": "bob"}, "repo": {"full_name": "acme/api"}, "created": "2024-01-02T09:00:00Z"},
+			{"id": 3, "op_type": "create_org", "act_user": {"login": "bob"}, "repo": null, "created": "2024-01-02T08:00:00Z"},
+			{"id": 4, "op_type": "transfer_repo", "act_user": {"login": "bob"}, "repo": {"full_name": "bob/site"}, "created": "2024-01-02T07:00:00Z"}
+		]`, next: "/orgs/acme/activities/feeds?limit=10&page=2"},
+		"/orgs/acme/activities/feeds?limit=10&page=2": {body: `[
+			{"id": 5, "op_type": "merge_pull_request", "act_user": {"login": "carol"}, "repo": {"full_name": "bob/site"}, "created": "2024-01-01T10:00:00Z"}
+		]`},
+		"/repos/acme/api": {body: `{
+			"name": "api", "full_name": "acme/api", "description": "the api", "forks_count": 2, "stars_count": 5, "topics": ["go"],
+			"owner": {"login": "acme"}, "licenses": ["Apache-2.0", "MIT"]
+		}`},
+		"/repos/acme/api/languages": {body: `{"Go": 5000}`},
+		"/repos/bob/site": {body: `{
+			"name": "site", "full_name": "bob/site", "description": "", "forks_count": 0, "stars_count": 0, "owner": {"login": "bob"}
+		}`},
+		"/repos/bob/site/languages": {body: `{"HTML": 100}`},
+	}}
+	service := serveAndMake(t, fake, "/orgs/acme/activities/feeds", 10, 10, func(options *Options) {
+		options.Forge = GiteaForge
+	})
+
+	byName := indexByName(t, service.List())
+	if len(byName) != 2 {
+		t.Fatalf("expected 2 repositories, got %v", byName)
+	}
+	if unexpected := fake.unexpected(); len(unexpected) != 0 {
+		t.Errorf("unexpected calls : %v", unexpected)
+	}
+
+	api := byName["acme/api"]
+	if api["owner"] != "acme" || api["license"] != "apache-2.0" || api["watchers_count"] != float64(5) {
+		t.Errorf("unexpected repository : %v", api)
+	}
+	if _, ok := api["organization"]; ok {
+		t.Errorf("unexpected organization : %v", api["organization"])
+	}
+	if eventTypes := eventTypesOf(api); fmt.Sprint(eventTypes) != "[PushEvent WatchEvent]" {
+		t.Errorf("unexpected event types : %v", eventTypes)
+	}
+	checkSchema(t, api, githubRepository(t, "owner1/repo1"))
+
+	site := byName["bob/site"]
+	if topics, ok := site["topics"].([]any); !ok || len(topics) != 0 {
+		t.Errorf("expected empty topics, got %v", site["topics"])
+	}
+	// unknown operations keep their name
+	if eventTypes := eventTypesOf(site); fmt.Sprint(eventTypes) != "[PullRequestEvent transfer_repo]" {
+		t.Errorf("unexpected event types : %v", eventTypes)
+	}
+}
@@ -0,0 +1,122 @@
+package repositoryservice
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
+// work with the activity feeds of Gitea and Forgejo (like "https://codeberg.org/api/v1/orgs/{org}/activities/feeds")
+type giteaForge struct {
+	client apiClient
+	apiUrl string
+}
+
+type giteaActivity struct {
+	Id      int64  `json:"id"`
+	OpType  string `json:"op_type"`
+	ActUser struct {
+		Login string `json:"login"`
+	} `json:"act_user"`
+	Repo *struct {
+		FullName string `json:"full_name"`
+	} `json:"repo"`
+	Created string `json:"created"`
+}
+
+type giteaRepository struct {
+	Name        string   `json:"name"`
+	FullName    string   `json:"full_name"`
+	Description string   `json:"description"`
+	ForksCount  int      `json:"forks_count"`
+	StarsCount  int      `json:"stars_count"`
+	Topics      []string `json:"topics"`
+	Owner       struct {
+		Login string `json:"login"`
+	} `json:"owner"`
+	Licenses []string `json:"licenses"` // SPDX identifiers, only with recent versions
+}
+
+var giteaOpTypes = map[string]string{
+	"commit_repo": "PushEvent", "mirror_sync_push": "PushEvent", "create_repo": "CreateEvent", "push_tag": "CreateEvent",
+	"delete_tag": "DeleteEvent", "delete_branch": "DeleteEvent", "star_repo": "WatchEvent", "fork_repo": "ForkEvent",
+	"create_issue": "IssuesEvent", "close_issue": "IssuesEvent", "reopen_issue": "IssuesEvent",
+	"create_pull_request": "PullRequestEvent", "merge_pull_request": "PullRequestEvent",
+	"close_pull_request": "PullRequestEvent", "reopen_pull_request": "PullRequestEvent",
+	"comment_issue": "IssueCommentEvent", "comment_pull": "IssueCommentEvent", "publish_release": "ReleaseEvent",
+}
+
+// Gitea use "limit" instead of "per_page"
+func (f giteaForge) FirstPageUrl(sourceUrl string, pageSize int) string {
+	return addQueryParam(sourceUrl, "limit", strconv.Itoa(pageSize))
+}
+
+func (f giteaForge) ListActivity(ctx context.Context, pageUrl string) ([]Activity, string, bool) {
+	data, header := f.client.get(ctx, pageUrl)
+	if len(data) == 0 {
+		return nil, "", false
+	}
+
+	var giteaActivities []giteaActivity
+	if err := json.Unmarshal(data, &giteaActivities); err != nil {
+		f.client.log.WithError(err).Error("Fail to parse activity api response")
+		return nil, "", false
+	}
+
+	activities := make([]Activity, 0, len(giteaActivities))
+	for _, activity := range giteaActivities {
+		var ref RepositoryRef
+		if activity.Repo != nil && activity.Repo.FullName != "" {
+			ref = RepositoryRef{Url: f.apiUrl + "/repos/" + activity.Repo.FullName, FullName: activity.Repo.FullName}
+		}
+
+		activityType, ok := giteaOpTypes[activity.OpType]
+		if !ok {
+			activityType = activity.OpType
+		}
+		activities = append(activities, Activity{
+			Id: strconv.FormatInt(activity.Id, 10), Type: activityType, Actor: activity.ActUser.Login,
+			CreatedAt: parseTime(activity.Created), Repository: ref,
+		})
+	}
+	return activities, linkUrl(header.Get("Link"), "next"), true
+}
+
+// the owner type is not available in the repository payload, so there is no organization field
+func (f giteaForge) FetchRepository(ctx context.Context, ref RepositoryRef) (JsonObject, bool) {
+	data, _ := f.client.get(ctx, ref.Url)
+	if len(data) == 0 {
+		return nil, false
+	}
+
+	var repository giteaRepository
+	if err := json.Unmarshal(data, &repository); err != nil {
+		f.client.log.WithError(err).Error("Fail to parse repository api response")
+		return nil, false
+	}
+
+	topics := make([]any, 0, len(repository.Topics))
+	for _, topic := range repository.Topics {
+		topics = append(topics, topic)
+	}
+
+	cleanedRepository := make(JsonObject, cleanedSize)
+	cleanedRepository["name"] = repository.Name
+	cleanedRepository["full_name"] = repository.FullName
+	cleanedRepository["description"] = repository.Description
+	cleanedRepository["forks_count"] = float64(repository.ForksCount)
+	cleanedRepository["watchers_count"] = float64(repository.StarsCount)
+	cleanedRepository["topics"] = topics
+	cleanedRepository["owner"] = repository.Owner.Login
+	if len(repository.Licenses) != 0 {
+		// GitHub license keys are lower case SPDX identifiers
+		cleanedRepository["license"] = strings.ToLower(repository.Licenses[0])
+	}
+	return cleanedRepository, true
+}
+
+// sizes in bytes by language
+func (f giteaForge) FetchLanguages(ctx context.Context, ref RepositoryRef) (JsonObject, bool) {
+	return fetchJsonObject(ctx, f.client, ref.Url+"/languages")
+}
@@ -0,0 +1,109 @@
+package repositoryservice
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+)
+
+var (
+	keepField = map[string]empty{
+		"name": marker, "full_name": marker, "description": marker, "forks_count": marker, "watchers_count": marker, "topics": marker,
+	}
+
+	flattenField = map[string]string{
+		"owner": "login", "license": "key", "organization": "login",
+	}
+)
+
+type githubForge struct {
+	client apiClient
+}
+
+type githubEvent struct {
+	Id    string `json:"id"`
+	Type  string `json:"type"`
+	Actor struct {
+		Login string `json:"login"`
+	} `json:"actor"`
+	Repo struct {
+		Name string `json:"name"`
+		Url  string `json:"url"`
+	} `json:"repo"`
+	CreatedAt string `json:"created_at"`
+}
+
+func (f githubForge) FirstPageUrl(sourceUrl string, pageSize int) string {
+	return addQueryParam(sourceUrl, "per_page", strconv.Itoa(pageSize))
+}
+
+func (f githubForge) ListActivity(ctx context.Context, pageUrl string) ([]Activity, string, bool) {
+	data, header := f.client.get(ctx, pageUrl)
+	if len(data) == 0 {
+		return nil, "", false
+	}
+
+	var events []githubEvent
+	if err := json.Unmarshal(data, &events); err != nil {
+		f.client.log.WithError(err).Error("Fail to parse event api response")
+		return nil, "", false
+	}
+
+	activities := make([]Activity, 0, len(events))
+	for _, event := range events {
+		activities = append(activities, Activity{
+			Id: event.Id, Type: event.Type, Actor: event.Actor.Login, CreatedAt: parseTime(event.CreatedAt),
+			Repository: RepositoryRef{Url: event.Repo.Url, FullName: event.Repo.Name},
+		})
+	}
+	return activities, linkUrl(header.Get("Link"), "next"), true
+}
+
+func (f githubForge) FetchRepository(ctx context.Context, ref RepositoryRef) (JsonObject, bool) {
+	repositoryData, _ := f.client.get(ctx, ref.Url)
+	if len(repositoryData) == 0 {
+		return nil, false
+	}
+
+	var repository JsonObject
+	if err := json.Unmarshal(repositoryData, &repository); err != nil {
+		f.client.log.WithError(err).Error("Fail to parse repository api response")
+		return nil, false
+	}
+
+	cleanedRepository := make(JsonObject, cleanedSize)
+	for key, value := range repository {
+		if _, ok := keepField[key]; ok {
+			cleanedRepository[key] = value
+			continue
+		}
+
+		if subKey, ok := flattenField[key]; ok {
+			if castedValue, okCast := value.(JsonObject); okCast {
+				cleanedRepository[key] = castedValue[subKey]
+			} else {
+				f.client.log.WithField("flattenField", key).Warn("Unable to flatten : can not cast to JsonObject")
+			}
+		}
+	}
+	return cleanedRepository, true
+}
+
+// sizes in bytes by language
+func (f githubForge) FetchLanguages(ctx context.Context, ref RepositoryRef) (JsonObject, bool) {
+	return fetchJsonObject(ctx, f.client, ref.Url+"/languages")
+}
+
+func fetchJsonObject(ctx context.Context, client apiClient, callUrl string) (JsonObject, bool) {
+	data, _ := client.get(ctx, callUrl)
+	if len(data) == 0 {
+		return nil, false
+	}
+
+	var parsed JsonObject
+	if err := json.Unmarshal(data, &parsed); err != nil {
+		client.log.WithError(err).Error("Fail to parse fetched response")
+		return nil, false
+	}
+	return parsed, true
+}
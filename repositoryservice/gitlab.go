@@ -0,0 +1,142 @@
+package repositoryservice
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
+// work with the event API of GitLab (like "https://gitlab.com/api/v4/projects/{id}/events" or "https://gitlab.com/api/v4/users/{id}/events")
+type gitlabForge struct {
+	client apiClient
+	apiUrl string
+}
+
+type gitlabEvent struct {
+	Id         int64  `json:"id"`
+	ActionName string `json:"action_name"`
+	TargetType string `json:"target_type"`
+	ProjectId  int64  `json:"project_id"`
+	Author     struct {
+		Username string `json:"username"`
+	} `json:"author"`
+	CreatedAt string `json:"created_at"`
+}
+
+type gitlabProject struct {
+	Name              string   `json:"name"`
+	PathWithNamespace string   `json:"path_with_namespace"`
+	Description       *string  `json:"description"`
+	ForksCount        int      `json:"forks_count"`
+	StarCount         int      `json:"star_count"`
+	Topics            []string `json:"topics"`
+	Namespace         struct {
+		Kind     string `json:"kind"` // "user" or "group"
+		FullPath string `json:"full_path"`
+	} `json:"namespace"`
+	Owner *struct {
+		Username string `json:"username"`
+	} `json:"owner"` // missing for group projects
+	License *struct {
+		Key string `json:"key"`
+	} `json:"license"`
+}
+
+func (f gitlabForge) FirstPageUrl(sourceUrl string, pageSize int) string {
+	return addQueryParam(sourceUrl, "per_page", strconv.Itoa(pageSize))
+}
+
+func (f gitlabForge) ListActivity(ctx context.Context, pageUrl string) ([]Activity, string, bool) {
+	data, header := f.client.get(ctx, pageUrl)
+	if len(data) == 0 {
+		return nil, "", false
+	}
+
+	var events []gitlabEvent
+	if err := json.Unmarshal(data, &events); err != nil {
+		f.client.log.WithError(err).Error("Fail to parse event api response")
+		return nil, "", false
+	}
+
+	activities := make([]Activity, 0, len(events))
+	for _, event := range events {
+		var ref RepositoryRef
+		if event.ProjectId != 0 { // events without project (like user joining) leave an empty url
+			ref.Url = f.apiUrl + "/projects/" + strconv.FormatInt(event.ProjectId, 10)
+		}
+		activities = append(activities, Activity{
+			Id: strconv.FormatInt(event.Id, 10), Type: gitlabEventType(event.ActionName, event.TargetType),
+			Actor: event.Author.Username, CreatedAt: parseTime(event.CreatedAt), Repository: ref,
+		})
+	}
+	return activities, linkUrl(header.Get("Link"), "next"), true
+}
+
+func (f gitlabForge) FetchRepository(ctx context.Context, ref RepositoryRef) (JsonObject, bool) {
+	data, _ := f.client.get(ctx, ref.Url+"?license=true")
+	if len(data) == 0 {
+		return nil, false
+	}
+
+	var project gitlabProject
+	if err := json.Unmarshal(data, &project); err != nil {
+		f.client.log.WithError(err).Error("Fail to parse project api response")
+		return nil, false
+	}
+
+	topics := make([]any, 0, len(project.Topics))
+	for _, topic := range project.Topics {
+		topics = append(topics, topic)
+	}
+
+	cleanedRepository := make(JsonObject, cleanedSize)
+	cleanedRepository["name"] = project.Name
+	cleanedRepository["full_name"] = project.PathWithNamespace
+	cleanedRepository["description"] = stringOrNil(project.Description)
+	cleanedRepository["forks_count"] = float64(project.ForksCount)
+	cleanedRepository["watchers_count"] = float64(project.StarCount)
+	cleanedRepository["topics"] = topics
+	if project.Owner == nil {
+		cleanedRepository["owner"] = project.Namespace.FullPath
+	} else {
+		cleanedRepository["owner"] = project.Owner.Username
+	}
+	if project.Namespace.Kind == "group" {
+		cleanedRepository["organization"] = project.Namespace.FullPath
+	}
+	if project.License != nil {
+		cleanedRepository["license"] = project.License.Key
+	}
+	return cleanedRepository, true
+}
+
+// GitLab return percentages by language (not sizes in bytes like GitHub)
+func (f gitlabForge) FetchLanguages(ctx context.Context, ref RepositoryRef) (JsonObject, bool) {
+	return fetchJsonObject(ctx, f.client, ref.Url+"/languages")
+}
+
+func gitlabEventType(actionName string, targetType string) string {
+	switch {
+	case strings.HasPrefix(actionName, "pushed"):
+		return "PushEvent"
+	case targetType == "MergeRequest":
+		return "PullRequestEvent"
+	case targetType == "Issue":
+		return "IssuesEvent"
+	case strings.HasSuffix(targetType, "Note"): // Note, DiffNote or DiscussionNote
+		return "IssueCommentEvent"
+	case actionName == "joined" || actionName == "left":
+		return "MemberEvent"
+	case actionName == "created" && targetType == "":
+		return "CreateEvent"
+	}
+	return actionName
+}
+
+func stringOrNil(value *string) any {
+	if value == nil {
+		return nil
+	}
+	return *value
+}
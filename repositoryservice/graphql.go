@@ -0,0 +1,174 @@
+package repositoryservice
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/dvaumoron/sclng-backend-test-v1/limitedconcurrent"
+)
+
+const (
+	RestBackend    = "rest"
+	GraphqlBackend = "graphql"
+
+	// same data as the cleaned REST response (watchers_count of REST API is the stargazer count)
+	repositoryFragment = `fragment repo on Repository {
+  name nameWithOwner description forkCount stargazerCount
+  repositoryTopics(first: 100) { nodes { topic { name } } }
+  licenseInfo { key }
+  owner { __typename login }
+  languages(first: 100) { edges { size node { name } } }
+}`
+)
+
+type graphqlResponse struct {
+	Data   map[string]*graphqlRepository `json:"data"`
+	Errors []struct {
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+type graphqlRepository struct {
+	Name             string  `json:"name"`
+	NameWithOwner    string  `json:"nameWithOwner"`
+	Description      *string `json:"description"`
+	ForkCount        int     `json:"forkCount"`
+	StargazerCount   int     `json:"stargazerCount"`
+	RepositoryTopics struct {
+		Nodes []struct {
+			Topic struct {
+				Name string `json:"name"`
+			} `json:"topic"`
+		} `json:"nodes"`
+	} `json:"repositoryTopics"`
+	LicenseInfo *struct {
+		Key string `json:"key"`
+	} `json:"licenseInfo"`
+	Owner struct {
+		Typename string `json:"__typename"`
+		Login    string `json:"login"`
+	} `json:"owner"`
+	Languages struct {
+		Edges []struct {
+			Size int `json:"size"`
+			Node struct {
+				Name string `json:"name"`
+			} `json:"node"`
+		} `json:"edges"`
+	} `json:"languages"`
+}
+
+// one GraphQL query by batch of repositories instead of several REST calls by repository
+func (r retriever) retrieveRepositoriesGraphql(ctx context.Context, activities map[string]*repositoryActivity) []JsonObject {
+	senders := make([]func(chan<- JsonObject), 0, len(activities)/r.graphqlBatchSize+1)
+	batch := make([]*repositoryActivity, 0, r.graphqlBatchSize)
+	for _, activity := range activities {
+		if _, _, ok := strings.Cut(activity.ref.FullName, "/"); !ok {
+			r.log.WithField("fullName", activity.ref.FullName).Error("Unable to query repository : invalid name")
+			continue
+		}
+
+		batch = append(batch, activity)
+		if len(batch) == r.graphqlBatchSize {
+			senders = append(senders, r.makeGraphqlSender(ctx, batch))
+			batch = make([]*repositoryActivity, 0, r.graphqlBatchSize)
+		}
+	}
+	if len(batch) != 0 {
+		senders = append(senders, r.makeGraphqlSender(ctx, batch))
+	}
+
+	// launch calls on the shared pool (limited parallelism)
+	return limitedconcurrent.LaunchPooled(ctx, r.pool, senders)
+}
+
+func (r retriever) makeGraphqlSender(ctx context.Context, batch []*repositoryActivity) func(chan<- JsonObject) {
+	return func(repositoryChan chan<- JsonObject) {
+		if ctx.Err() == nil { // deadline not exceeded
+			r.retrieveGraphqlBatch(ctx, repositoryChan, batch)
+		}
+	}
+}
+
+func (r retriever) retrieveGraphqlBatch(ctx context.Context, repositoryChan chan<- JsonObject, batch []*repositoryActivity) {
+	var queryBuilder strings.Builder
+	queryBuilder.WriteString("query {\n")
+	for index, activity := range batch {
+		owner, name, _ := strings.Cut(activity.ref.FullName, "/")
+		// aliases allow to query several repositories at once
+		queryBuilder.WriteString("  r")
+		queryBuilder.WriteString(strconv.Itoa(index))
+		queryBuilder.WriteString(": repository(owner: ")
+		queryBuilder.WriteString(strconv.Quote(owner))
+		queryBuilder.WriteString(", name: ")
+		queryBuilder.WriteString(strconv.Quote(name))
+		queryBuilder.WriteString(") { ...repo }\n")
+	}
+	queryBuilder.WriteString("}\n")
+	queryBuilder.WriteString(repositoryFragment)
+
+	body, err := json.Marshal(map[string]string{"query": queryBuilder.String()})
+	if err != nil {
+		r.log.WithError(err).Error("Fail to build graphql request")
+		return
+	}
+
+	data, _ := r.client.request(ctx, http.MethodPost, r.graphqlUrl, body)
+	if len(data) == 0 {
+		return
+	}
+
+	var response graphqlResponse
+	if err = json.Unmarshal(data, &response); err != nil {
+		r.log.WithError(err).Error("Fail to parse graphql api response")
+		return
+	}
+	for _, graphqlError := range response.Errors {
+		// partial response, missing repositories are counted as repository errors
+		r.log.WithField("message", graphqlError.Message).Warn("Error in graphql api response")
+	}
+
+	for index, activity := range batch {
+		if repository := response.Data["r"+strconv.Itoa(index)]; repository != nil {
+			repositoryChan <- repository.clean(activity)
+		}
+	}
+}
+
+// produce the same fields than the REST cleaning (with float64 as numeric type, like encoding/json)
+func (gr *graphqlRepository) clean(activity *repositoryActivity) JsonObject {
+	topics := make([]any, 0, len(gr.RepositoryTopics.Nodes))
+	for _, node := range gr.RepositoryTopics.Nodes {
+		topics = append(topics, node.Topic.Name)
+	}
+
+	languages := make(JsonObject, len(gr.Languages.Edges))
+	for _, edge := range gr.Languages.Edges {
+		languages[edge.Node.Name] = float64(edge.Size)
+	}
+
+	cleanedRepository := make(JsonObject, cleanedSize)
+	cleanedRepository["name"] = gr.Name
+	cleanedRepository["full_name"] = gr.NameWithOwner
+	if gr.Description == nil {
+		cleanedRepository["description"] = nil
+	} else {
+		cleanedRepository["description"] = *gr.Description
+	}
+	cleanedRepository["forks_count"] = float64(gr.ForkCount)
+	cleanedRepository["watchers_count"] = float64(gr.StargazerCount)
+	cleanedRepository["topics"] = topics
+	cleanedRepository["owner"] = gr.Owner.Login
+	if gr.Owner.Typename == "Organization" {
+		cleanedRepository["organization"] = gr.Owner.Login
+	}
+	if gr.LicenseInfo != nil {
+		cleanedRepository["license"] = gr.LicenseInfo.Key
+	}
+	cleanedRepository["languages"] = languages
+	cleanedRepository["source"], cleanedRepository["events"] = activity.toJson()
+	return cleanedRepository
+}
@@ -0,0 +1,69 @@
+package repositoryservice
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+type HttpOptions struct {
+	Timeout  time.Duration // by request, including the read of the response body
+	MaxConn  int           // idle connections kept by host, should match the number of concurrent calls
+	ProxyUrl string        // proxy from environment (HTTPS_PROXY, NO_PROXY) when empty
+	CaFile   string        // PEM certificates trusted in addition to the system ones
+	// optional, allow to observe or replace calls (like recording responses or calling a fake)
+	WrapTransport func(http.RoundTripper) http.RoundTripper
+}
+
+// the transport handle gzip compression transparently and try HTTP/2 with TLS servers
+func MakeHttpClient(options HttpOptions) (*http.Client, error) {
+	proxy := http.ProxyFromEnvironment
+	if options.ProxyUrl != "" {
+		parsedUrl, err := url.Parse(options.ProxyUrl)
+		if err != nil {
+			return nil, errors.Wrap(err, "fail to parse proxy url")
+		}
+		proxy = http.ProxyURL(parsedUrl)
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if options.CaFile != "" {
+		caPool, err := x509.SystemCertPool()
+		if err != nil {
+			caPool = x509.NewCertPool()
+		}
+
+		certificates, err := os.ReadFile(options.CaFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "fail to read CA file")
+		}
+		if !caPool.AppendCertsFromPEM(certificates) {
+			return nil, errors.Errorf("no certificate found in CA file %q", options.CaFile)
+		}
+		tlsConfig.RootCAs = caPool
+	}
+
+	dialer := &net.Dialer{Timeout: 10 * time.Second, KeepAlive: 30 * time.Second}
+	transport := &http.Transport{
+		Proxy:                 proxy,
+		DialContext:           dialer.DialContext,
+		TLSClientConfig:       tlsConfig,
+		ForceAttemptHTTP2:     true, // needed with a custom TLS config
+		MaxIdleConns:          options.MaxConn,
+		MaxIdleConnsPerHost:   options.MaxConn, // default of 2 would reopen connections for most concurrent calls
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: time.Second,
+	}
+	var roundTripper http.RoundTripper = transport
+	if options.WrapTransport != nil {
+		roundTripper = options.WrapTransport(transport)
+	}
+	return &http.Client{Transport: roundTripper, Timeout: options.Timeout}, nil
+}
@@ -0,0 +1,70 @@
+package repositoryservice
+
+import (
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/dvaumoron/sclng-backend-test-v1/limitedconcurrent"
+)
+
+const minThrottledRate = 0.1 // by second, even without remaining quota (calls will fail until the reset)
+
+// last rate limit seen in forge API responses
+type rateLimitState struct {
+	remaining atomic.Int64
+	reset     atomic.Int64 // unix time of the quota reset
+}
+
+func newRateLimitState() *rateLimitState {
+	state := &rateLimitState{}
+	state.remaining.Store(-1) // unknown until the first response
+	return state
+}
+
+func (s *rateLimitState) update(header http.Header) {
+	value := header.Get("X-RateLimit-Remaining")
+	if value == "" {
+		value = header.Get("RateLimit-Remaining") // GitLab name
+	}
+	if remaining, err := strconv.ParseInt(value, 10, 64); err == nil {
+		s.remaining.Store(remaining)
+	}
+
+	value = header.Get("X-RateLimit-Reset")
+	if value == "" {
+		value = header.Get("RateLimit-Reset")
+	}
+	if reset, err := strconv.ParseInt(value, 10, 64); err == nil {
+		s.reset.Store(reset)
+	}
+}
+
+// slow down calls to spread the remaining quota until its reset, when it is under throttleRemaining
+type throttle struct {
+	scheduler         limitedconcurrent.Scheduler
+	limits            limitedconcurrent.Limits // configured limits
+	throttleRemaining int
+	lastRate          atomic.Uint64 // rate (in thousandth) last sent to the scheduler, avoid useless updates
+}
+
+func (t *throttle) adjust(tokens *tokenPool) {
+	limits := t.limits
+	remaining, reset := tokens.budget()
+	if remaining >= 0 && remaining < int64(t.throttleRemaining) {
+		rate := minThrottledRate
+		if untilReset := time.Until(time.Unix(reset, 0)).Seconds(); untilReset > 0 {
+			if spread := float64(remaining) / untilReset; spread > rate {
+				rate = spread
+			}
+		}
+		if limits.Rate <= 0 || rate < limits.Rate {
+			limits.Rate = rate
+		}
+	}
+
+	if rate := uint64(limits.Rate * 1000); t.lastRate.Swap(rate) != rate {
+		t.scheduler.SetLimits(limits)
+	}
+}
@@ -0,0 +1,111 @@
+package repositoryservice
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	FixedMode    = "fixed"
+	LazyMode     = "lazy"
+	AdaptiveMode = "adaptive"
+)
+
+type RefreshStrategy interface {
+	// delay before the next automatic refresh (idle is the time elapsed since the last read),
+	// a negative value disable the automatic refresh
+	delay(idle time.Duration) time.Duration
+	// indicates if a read of data of this age should trigger a refresh (ignored when one is running)
+	onRead(age time.Duration) bool
+}
+
+type fixedStrategy struct {
+	interval time.Duration
+}
+
+type lazyStrategy struct {
+	ttl time.Duration
+}
+
+type adaptiveStrategy struct {
+	interval    time.Duration
+	idleTimeout time.Duration
+	maxInterval time.Duration
+}
+
+// only the durations used by the mode are checked, they must be positive
+func MakeRefreshStrategy(mode string, interval time.Duration, ttl time.Duration, idleTimeout time.Duration, maxInterval time.Duration) (RefreshStrategy, error) {
+	switch mode {
+	case FixedMode:
+		if interval <= 0 {
+			return nil, errors.Errorf("refresh interval must be positive, got %s", interval)
+		}
+		return FixedRefresh(interval), nil
+	case LazyMode:
+		if ttl <= 0 {
+			return nil, errors.Errorf("refresh ttl must be positive, got %s", ttl)
+		}
+		return LazyRefresh(ttl), nil
+	case AdaptiveMode:
+		switch {
+		case interval <= 0:
+			return nil, errors.Errorf("refresh interval must be positive, got %s", interval)
+		case idleTimeout <= 0:
+			return nil, errors.Errorf("refresh idle timeout must be positive, got %s", idleTimeout)
+		case maxInterval < interval:
+			return nil, errors.Errorf("maximum refresh interval (%s) must not be lower than the refresh interval (%s)", maxInterval, interval)
+		}
+		return AdaptiveRefresh(interval, idleTimeout, maxInterval), nil
+	}
+	return nil, errors.Errorf("unknown refresh mode %q", mode)
+}
+
+// refresh at each interval
+func FixedRefresh(interval time.Duration) RefreshStrategy {
+	return fixedStrategy{interval: interval}
+}
+
+// refresh only when data older than ttl are read (stale data are returned while the refresh is running)
+func LazyRefresh(ttl time.Duration) RefreshStrategy {
+	return lazyStrategy{ttl: ttl}
+}
+
+// refresh at each interval while data are read, without read during idleTimeout,
+// the interval grows with the idle time (up to maxInterval) and the first read of data older than interval trigger a refresh
+func AdaptiveRefresh(interval time.Duration, idleTimeout time.Duration, maxInterval time.Duration) RefreshStrategy {
+	return adaptiveStrategy{interval: interval, idleTimeout: idleTimeout, maxInterval: maxInterval}
+}
+
+func (s fixedStrategy) delay(time.Duration) time.Duration {
+	return s.interval
+}
+
+func (s fixedStrategy) onRead(time.Duration) bool {
+	return false
+}
+
+func (s lazyStrategy) delay(time.Duration) time.Duration {
+	return -1
+}
+
+func (s lazyStrategy) onRead(age time.Duration) bool {
+	return age >= s.ttl
+}
+
+func (s adaptiveStrategy) delay(idle time.Duration) time.Duration {
+	if idle <= s.idleTimeout {
+		return s.interval
+	}
+
+	// slow down proportionally to the idle time (compared before the conversion, which could overflow)
+	slowed := float64(s.interval) * float64(idle) / float64(s.idleTimeout)
+	if slowed > float64(s.maxInterval) {
+		return s.maxInterval
+	}
+	return time.Duration(slowed)
+}
+
+func (s adaptiveStrategy) onRead(age time.Duration) bool {
+	return age >= s.interval
+}
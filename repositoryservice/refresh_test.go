@@ -0,0 +1,127 @@
+package repositoryservice
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dvaumoron/sclng-backend-test-v1/githubfake"
+)
+
+func TestMakeRefreshStrategyRejectsDurations(t *testing.T) {
+	testCases := []struct {
+		mode        string
+		interval    time.Duration
+		ttl         time.Duration
+		idleTimeout time.Duration
+		maxInterval time.Duration
+		valid       bool
+	}{
+		{mode: FixedMode, interval: time.Minute, valid: true},
+		{mode: FixedMode, interval: 0},
+		{mode: FixedMode, interval: -time.Minute},
+		{mode: LazyMode, ttl: time.Minute, valid: true},
+		{mode: LazyMode, interval: time.Minute, ttl: 0},
+		{mode: AdaptiveMode, interval: time.Minute, idleTimeout: time.Minute, maxInterval: time.Minute, valid: true},
+		{mode: AdaptiveMode, interval: 0, idleTimeout: time.Minute, maxInterval: time.Minute},
+		{mode: AdaptiveMode, interval: time.Minute, idleTimeout: 0, maxInterval: time.Hour},
+		{mode: AdaptiveMode, interval: time.Hour, idleTimeout: time.Minute, maxInterval: time.Minute},
+		{mode: "unknown", interval: time.Minute, ttl: time.Minute, idleTimeout: time.Minute, maxInterval: time.Minute},
+	}
+	for _, testCase := range testCases {
+		strategy, err := MakeRefreshStrategy(testCase.mode, testCase.interval, testCase.ttl, testCase.idleTimeout, testCase.maxInterval)
+		if testCase.valid && (err != nil || strategy == nil) {
+			t.Errorf("%+v : unexpected error : %v", testCase, err)
+		}
+		if !testCase.valid && err == nil {
+			t.Errorf("%+v : expected an error", testCase)
+		}
+	}
+}
+
+func TestAdaptiveDelay(t *testing.T) {
+	strategy := AdaptiveRefresh(time.Minute, 10*time.Minute, time.Hour)
+	testCases := []struct {
+		idle     time.Duration
+		expected time.Duration
+	}{
+		{idle: 0, expected: time.Minute},
+		{idle: 10 * time.Minute, expected: time.Minute},
+		{idle: 30 * time.Minute, expected: 3 * time.Minute},
+		{idle: 24 * time.Hour, expected: time.Hour},
+		{idle: time.Duration(1<<63 - 1), expected: time.Hour}, // no overflow
+	}
+	for _, testCase := range testCases {
+		if delay := strategy.delay(testCase.idle); delay != testCase.expected {
+			t.Errorf("idle %s : expected %s, got %s", testCase.idle, testCase.expected, delay)
+		}
+	}
+}
+
+// each refresh lasts more than 100ms (sequential calls delayed by 40ms)
+func startSlowService(t *testing.T, strategy RefreshStrategy, overlapPolicy string) RepositoryService {
+	t.Helper()
+	fakeOptions := githubfake.Options{RepositoryCount: 10, EventCount: 10, Delay: 40 * time.Millisecond}
+	service, _ := startConfiguredService(t, fakeOptions, 10, 1, func(options *Options) {
+		options.Strategy, options.OverlapPolicy = strategy, overlapPolicy
+	})
+	return service
+}
+
+// wait until a snapshot with at least this refresh id is published
+func waitPublished(t *testing.T, service RepositoryService, refreshId uint64) Snapshot {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		snapshot, _ := service.Peek()
+		if snapshot.RefreshId >= refreshId {
+			return snapshot
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("refresh %d not published, last one is %d", refreshId, snapshot.RefreshId)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestOverrunIsSkipped(t *testing.T) {
+	service := startSlowService(t, FixedRefresh(50*time.Millisecond), SkipOverlap)
+
+	snapshot := waitPublished(t, service, 3)
+	if snapshot.Stats.SkippedOverruns == 0 || snapshot.Stats.QueuedOverruns != 0 {
+		t.Errorf("expected skipped overruns only, got %+v", snapshot.Stats)
+	}
+}
+
+func TestOverrunIsQueued(t *testing.T) {
+	service := startSlowService(t, FixedRefresh(50*time.Millisecond), QueueOverlap)
+
+	snapshot := waitPublished(t, service, 3)
+	if snapshot.Stats.QueuedOverruns == 0 || snapshot.Stats.SkippedOverruns != 0 {
+		t.Errorf("expected queued overruns only, got %+v", snapshot.Stats)
+	}
+}
+
+func TestNoOverrunWhenRefreshIsShorter(t *testing.T) {
+	service := startSlowService(t, FixedRefresh(300*time.Millisecond), QueueOverlap)
+
+	snapshot := waitPublished(t, service, 3)
+	if snapshot.Stats != (RefreshStats{}) {
+		t.Errorf("unexpected overruns : %+v", snapshot.Stats)
+	}
+}
+
+// a refresh started by a read plans the next automatic one, the timer can not fire while it runs
+func TestNoOverrunWhenReadStartsRefresh(t *testing.T) {
+	// the automatic delay grows quickly without read, while a read of data older than 200ms start a refresh
+	service := startSlowService(t, AdaptiveRefresh(200*time.Millisecond, 50*time.Millisecond, time.Hour), QueueOverlap)
+
+	time.Sleep(500 * time.Millisecond)
+	for end := time.Now().Add(time.Second); time.Now().Before(end); {
+		service.TrySnapshot()
+		time.Sleep(5 * time.Millisecond)
+	}
+	snapshot := waitPublished(t, service, 3)
+	if snapshot.Stats != (RefreshStats{}) {
+		t.Errorf("unexpected overruns : %+v", snapshot.Stats)
+	}
+}
@@ -1,202 +1,319 @@
 package repositoryservice
 
 import (
-	"encoding/json"
-	"io"
+	"context"
 	"net/http"
-	"strconv"
-	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/dvaumoron/sclng-backend-test-v1/limitedconcurrent"
 	"github.com/sirupsen/logrus"
 )
 
+const (
+	SkipOverlap  = "skip"
+	QueueOverlap = "queue"
+
+	progressLogDelay = 5 * time.Second
+)
+
 type empty = struct{}
 type JsonObject = map[string]any
 
-type RepositoryService <-chan []JsonObject
-
-var (
-	marker = empty{}
+type RepositoryService struct {
+	readyChan   <-chan Snapshot
+	currentChan <-chan Snapshot
+	peekChan    <-chan Snapshot // reads which are not counted by the refresh strategy
+	triggerChan chan<- chan<- RefreshTicket
+	pool        limitedconcurrent.Pool
+	progress    *atomic.Pointer[refreshProgress] // of the last started refresh
+}
 
-	keepField = map[string]empty{
-		"name": marker, "full_name": marker, "description": marker, "forks_count": marker, "watchers_count": marker, "topics": marker,
-	}
+type Options struct {
+	Forge             string // GithubForge, GitlabForge or GiteaForge
+	Sources           []EventSource
+	IncludedTypes     []string // event types used to collect repositories (all when empty)
+	ExcludedTypes     []string
+	EventPageSize     int
+	MaxPage           int    // maximum number of event pages read by source and refresh
+	Backend           string // RestBackend or GraphqlBackend, used to fetch repositories data
+	GraphqlUrl        string
+	GraphqlBatch      int // number of repositories by GraphQL query
+	ApiUrl            string
+	Enrichers         []Enricher
+	MinRemaining      int // enrichers are skipped when the remaining rate limit is lower
+	Strategy          RefreshStrategy
+	OverlapPolicy     string        // SkipOverlap or QueueOverlap, action for an automatic refresh requested while one is running
+	RefreshDeadline   time.Duration // maximum duration of a refresh
+	MaxCall           int           // maximum number of concurrent api calls, shared by all refreshes
+	CallRate          float64       // maximum number of api calls by second (unlimited when not positive)
+	CallBurst         int
+	ThrottleRemaining int // calls are slowed down to spread the remaining quota until its reset when it is lower
+	Auth              Authentication
+	HttpClient        *http.Client
+	UserAgent         string
+}
 
-	flattenField = map[string]string{
-		"owner": "login", "license": "key", "organization": "login",
-	}
+type RefreshTicket struct {
+	Id        uint64
+	Coalesced bool         // true when the trigger joined an already running refresh
+	Done      <-chan empty // closed when the refresh ends (its snapshot is published unless it is discarded)
+	outcome   *refreshOutcome
+}
 
-	fetchField = map[string]string{
-		"languages_url": "languages",
-	}
+// written before the closing of the Done channel of the tickets
+type refreshOutcome struct {
+	discarded bool
+}
 
-	cleanedSize = len(keepField) + len(flattenField) + len(fetchField)
-)
+type refreshProgress struct {
+	refreshId uint64
+	tracker   *limitedconcurrent.ProgressTracker
+}
 
-func Make(log logrus.FieldLogger, eventApiUrl string, eventPageSize int, refresh time.Duration, maxCall int, accessToken string) RepositoryService {
-	var urlBuilder strings.Builder
-	urlBuilder.WriteString(eventApiUrl)
-	urlBuilder.WriteString("?per_page=")
-	urlBuilder.WriteString(strconv.Itoa(eventPageSize))
-	urlBuilder.WriteString("&page=")
+var marker = empty{}
 
-	var authorizationBuilder strings.Builder
-	authorizationBuilder.WriteString("Bearer ")
-	authorizationBuilder.WriteString(accessToken)
+func Make(log logrus.FieldLogger, options Options) RepositoryService {
+	headers := make(map[string]string, len(forgeHeaders[options.Forge])+1)
+	for name, value := range forgeHeaders[options.Forge] {
+		headers[name] = value
+	}
+	headers["User-Agent"] = options.UserAgent
+	limits := limitedconcurrent.Limits{Concurrency: options.MaxCall, Rate: options.CallRate, Burst: options.CallBurst}
+	scheduler := limitedconcurrent.NewScheduler(limits) // every api call is scheduled, nested or not
+	client := apiClient{
+		log: log, httpClient: options.HttpClient, headers: headers,
+		tokens:   newTokenPool(options.Auth.credentials(log, options.HttpClient, scheduler, headers, options.ApiUrl)),
+		throttle: &throttle{scheduler: scheduler, limits: limits, throttleRemaining: options.ThrottleRemaining},
+	}
+	pool := limitedconcurrent.NewPool(options.MaxCall, func(err *limitedconcurrent.PanicError) {
+		log.WithError(err).Error("Task panic recovered")
+	})
+	r := retriever{
+		log: log, backend: options.Backend, graphqlUrl: options.GraphqlUrl, graphqlBatchSize: options.GraphqlBatch, sources: options.Sources, includedTypes: makeSet(options.IncludedTypes),
+		excludedTypes: makeSet(options.ExcludedTypes), eventPageSize: options.EventPageSize, maxPage: options.MaxPage,
+		pool: pool, client: client, forge: newForge(options.Forge, client, options.ApiUrl),
+		apiUrl: options.ApiUrl, enrichers: options.Enrichers, enricherCache: newEnricherCache(), minRateRemaining: options.MinRemaining,
+	}
 
-	repositoriesChan := make(chan []JsonObject)
-	go manageUpdate(log, repositoriesChan, urlBuilder.String(), refresh, maxCall, authorizationBuilder.String())
-	return repositoriesChan
+	readyChan := make(chan Snapshot)
+	currentChan := make(chan Snapshot)
+	peekChan := make(chan Snapshot)
+	triggerChan := make(chan chan<- RefreshTicket)
+	progress := &atomic.Pointer[refreshProgress]{}
+	go manageUpdate(log, readyChan, currentChan, peekChan, triggerChan, progress, r, options)
+	return RepositoryService{readyChan: readyChan, currentChan: currentChan, peekChan: peekChan, triggerChan: triggerChan, pool: r.pool, progress: progress}
 }
 
-// ! no defensive copy of cached value
+// return a copy, modifying it does not change the cache
 func (rs RepositoryService) List() []JsonObject {
-	return <-rs // continuously receiving cache value
+	return rs.Snapshot().Repositories()
 }
 
-func manageUpdate(log logrus.FieldLogger, repositoriesChan chan<- []JsonObject, eventPageUrl string, refresh time.Duration, maxCall int, authorizationHeader string) {
-	repositoriesCache := retrieveRepositoriesData(log, eventPageUrl, maxCall, authorizationHeader)
-	repositoriesUpdateChan := make(chan []JsonObject)
-	// assumes update time is shorter than refresh tick
-	go updateCache(log, repositoriesUpdateChan, eventPageUrl, refresh, maxCall, authorizationHeader)
-	for {
-		// send last cache value or update it
-		select {
-		case repositoriesChan <- repositoriesCache:
-		case repositoriesCache = <-repositoriesUpdateChan:
-		}
-	}
+// block until the first retrieval is done
+func (rs RepositoryService) Snapshot() Snapshot {
+	return <-rs.readyChan // continuously receiving cache value
 }
 
-func retrieveRepositoriesData(log logrus.FieldLogger, eventPageUrl string, maxCall int, authorizationHeader string) []JsonObject {
-	urls := make(map[string]empty, 100)
-	for i := 1; len(urls) < 100; i++ {
-		extractRepositoriesUrl(log, urls, eventPageUrl, authorizationHeader, i)
-	}
+// never block on the first retrieval, the boolean indicates if the snapshot is ready
+func (rs RepositoryService) TrySnapshot() (Snapshot, bool) {
+	snapshot := <-rs.currentChan
+	return snapshot, snapshot.Ready()
+}
 
-	// prepare necessary github API calls
-	senders := make([]func(chan<- JsonObject), 0, len(urls))
-	for url := range urls {
-		urlCopy := url // avoid closure capture
-		senders = append(senders, func(repositoryChan chan<- JsonObject) {
-			retrieveRepositoryData(log, repositoryChan, urlCopy, authorizationHeader)
-		})
-	}
+// like TrySnapshot, but the read is not seen by the refresh strategy (for monitoring and administration)
+func (rs RepositoryService) Peek() (Snapshot, bool) {
+	snapshot := <-rs.peekChan
+	return snapshot, snapshot.Ready()
+}
 
-	// launch calls with a limitation on parallelism
-	return limitedconcurrent.LaunchLimited(senders, maxCall)
+// tasks of the worker pool shared by all refreshes
+func (rs RepositoryService) PoolStats() limitedconcurrent.PoolStats {
+	return rs.pool.Stats()
 }
 
-func updateCache(log logrus.FieldLogger, repositoriesUpdateChan chan<- []JsonObject, eventPageUrl string, refresh time.Duration, maxCall int, authorizationHeader string) {
-	for range time.Tick(refresh) {
-		// at each refresh interval, try to update cache
-		if data := retrieveRepositoriesData(log, eventPageUrl, maxCall, authorizationHeader); data != nil {
-			repositoriesUpdateChan <- data
-		}
+// progress of the api calls of the last started refresh (with its id), the boolean is false before the first one
+func (rs RepositoryService) Progress() (uint64, limitedconcurrent.Progress, bool) {
+	current := rs.progress.Load()
+	if current == nil {
+		return 0, limitedconcurrent.Progress{}, false
 	}
+	return current.refreshId, current.tracker.Progress(), true
 }
 
-func extractRepositoriesUrl(log logrus.FieldLogger, urls map[string]empty, eventPageUrl string, authorizationHeader string, page int) {
-	var urlBuilder strings.Builder
-	urlBuilder.WriteString(eventPageUrl)
-	urlBuilder.WriteString(strconv.Itoa(page))
+// start a refresh immediately (the next automatic refresh is planned from its start, like any refresh),
+// when a refresh is already running, the returned ticket is the one of the running refresh
+func (rs RepositoryService) Refresh() RefreshTicket {
+	ticketChan := make(chan RefreshTicket, 1)
+	rs.triggerChan <- ticketChan
+	return <-ticketChan
+}
 
-	data := githubApiGetRequest(log, urlBuilder.String(), authorizationHeader)
+// indicates that the refresh exceeded its deadline and that its partial data were not published
+// because complete ones were available, only meaningful once Done is closed
+func (t RefreshTicket) Discarded() bool {
+	return t.outcome.discarded
+}
 
-	var events []JsonObject
-	if err := json.Unmarshal(data, &events); err != nil {
-		log.WithError(err).Error("Fail to parse event api response")
-		return
+func manageUpdate(log logrus.FieldLogger, readyChan chan<- Snapshot, currentChan chan<- Snapshot, peekChan chan<- Snapshot, triggerChan <-chan chan<- RefreshTicket, progress *atomic.Pointer[refreshProgress], r retriever, options Options) {
+	snapshotUpdateChan := make(chan Snapshot)
+	partialChan := make(chan Snapshot)
+	snapshotCache := Snapshot{}
+
+	var refreshDone chan empty
+	var outcome *refreshOutcome
+	// monotonic, unlike the id of the cached snapshot which does not change when a refresh is discarded
+	var lastRefreshId uint64
+	lastRead := time.Now()
+
+	// automatic refreshes are planned from the start of the previous one (whatever started it),
+	// so a refresh lasting more than the delay is an overrun
+	timer := time.NewTimer(0)
+	stopTimer(timer)
+	defer timer.Stop()
+	planRefresh := func() {
+		stopTimer(timer)
+		if delay := options.Strategy.delay(time.Since(lastRead)); delay >= 0 {
+			timer.Reset(delay)
+		}
 	}
 
-	for _, event := range events {
-		if repo, ok := event["repo"].(JsonObject); ok {
-			if repoUrl, _ := repo["url"].(string); repoUrl != "" {
-				urls[repoUrl] = marker
+	// the priority of api calls in the shared pool
+	startRefresh := func(priority limitedconcurrent.Priority) {
+		planRefresh()
+		snapshotCache.Refreshing = true
+		refreshDone = make(chan empty)
+		outcome = &refreshOutcome{}
+		lastRefreshId++
+		refreshId := lastRefreshId
+		var publish func(Snapshot)
+		if !snapshotCache.Ready() || snapshotCache.Incomplete {
+			// partial data are only worth it when there is no complete data yet
+			publish = func(partial Snapshot) {
+				partialChan <- partial
 			}
 		}
-	}
-}
-
-func retrieveRepositoryData(log logrus.FieldLogger, repositoryChan chan<- JsonObject, repositoryUrl string, authorizationHeader string) {
-	repositoryData := githubApiGetRequest(log, repositoryUrl, authorizationHeader)
-	if len(repositoryData) == 0 {
-		return
-	}
-
-	var repository JsonObject
-	err := json.Unmarshal(repositoryData, &repository)
-	if err != nil {
-		log.WithError(err).Error("Fail to parse repository api response")
-		return
+		refreshLog := log.WithField("refresh_id", refreshId)
+		lastLog := time.Now()
+		tracker := limitedconcurrent.NewProgressTracker(func(current limitedconcurrent.Progress) {
+			if time.Since(lastLog) >= progressLogDelay { // observer calls are never concurrent
+				lastLog = time.Now()
+				refreshLog.WithFields(progressFields(current)).Info("Refresh in progress")
+			}
+		})
+		progress.Store(&refreshProgress{refreshId: refreshId, tracker: tracker})
+		go func() {
+			// hard deadline, every api call of the refresh is cancelled when it is exceeded
+			ctx, cancel := context.WithTimeout(limitedconcurrent.WithPriority(context.Background(), priority), options.RefreshDeadline)
+			defer cancel()
+
+			snapshot := r.retrieveSnapshot(limitedconcurrent.WithProgress(ctx, tracker), publish)
+			tracker.Finish()
+			refreshLog.WithFields(progressFields(tracker.Progress())).Info("Refresh ended")
+			snapshot.RefreshId = refreshId
+			snapshot.DeadlineExceeded = ctx.Err() != nil
+			snapshotUpdateChan <- snapshot
+		}()
 	}
 
-	cleanedRepository := make(JsonObject, cleanedSize)
-	for key, value := range repository {
-		if _, ok := keepField[key]; ok {
-			cleanedRepository[key] = value
-			continue
+	queued := false
+	// single flight : an automatic refresh never run concurrently with an other one
+	requestRefresh := func(reason string) {
+		if !snapshotCache.Refreshing {
+			startRefresh(limitedconcurrent.Background)
+			return
 		}
 
-		if subKey, ok := flattenField[key]; ok {
-			if castedValue, okCast := value.(JsonObject); okCast {
-				cleanedRepository[key] = castedValue[subKey]
-			} else {
-				log.WithField("flattenField", key).Warn("Unable to flatten : can not cast to JsonObject")
-			}
-			continue
+		overrunLog := log.WithField("reason", reason).WithField("refresh_id", lastRefreshId)
+		if options.OverlapPolicy == QueueOverlap {
+			snapshotCache.Stats.QueuedOverruns++
+			queued = true // the queued refresh plans the next one
+			overrunLog.Warn("Refresh still running, next one is queued")
+		} else {
+			snapshotCache.Stats.SkippedOverruns++
+			planRefresh()
+			overrunLog.Warn("Refresh still running, next one is skipped")
 		}
+	}
 
-		newKey, ok := fetchField[key]
-		if !ok {
-			continue
+	onRead := func() {
+		lastRead = time.Now()
+		if !snapshotCache.Refreshing && options.Strategy.onRead(lastRead.Sub(snapshotCache.FetchedAt)) {
+			requestRefresh("read")
 		}
+	}
 
-		url, _ := value.(string)
-		if url == "" {
-			log.WithField(key, value).Error("Unable to fetch url : empty or non string")
-			return
-		}
+	startRefresh(limitedconcurrent.OnDemand) // requests are waiting for the first retrieval
+	var readyChanOrNil chan<- Snapshot       // nil (so never selected) until the first retrieval is done
+	for {
+		// send last cache value, start a refresh or update cache
+		select {
+		case readyChanOrNil <- snapshotCache:
+			onRead()
+		case currentChan <- snapshotCache:
+			onRead()
+		case peekChan <- snapshotCache:
+		case <-timer.C:
+			requestRefresh("timer")
+		case ticketChan := <-triggerChan:
+			coalesced := snapshotCache.Refreshing
+			if !coalesced {
+				startRefresh(limitedconcurrent.OnDemand)
+			}
+			ticketChan <- RefreshTicket{Id: lastRefreshId, Coalesced: coalesced, Done: refreshDone, outcome: outcome}
+		case partial := <-partialChan:
+			if !snapshotCache.Ready() || snapshotCache.Incomplete {
+				partial.Stats = snapshotCache.Stats
+				snapshotCache = partial // refresh id stay zero until the first retrieval is done
+			}
+		case snapshot := <-snapshotUpdateChan:
+			snapshot.Stats = snapshotCache.Stats
+			if snapshot.DeadlineExceeded {
+				snapshot.Stats.DeadlineExceeded++
+				log.WithField("refresh_id", snapshot.RefreshId).WithField("deadline", options.RefreshDeadline).Error("Refresh deadline exceeded")
+			}
 
-		data := githubApiGetRequest(log, url, authorizationHeader)
-		if len(data) == 0 {
-			return
-		}
+			if snapshot.DeadlineExceeded && snapshotCache.Ready() && !snapshotCache.Incomplete {
+				// keep complete data rather than partial ones
+				outcome.discarded = true
+				log.WithField("refresh_id", snapshot.RefreshId).Warn("Partial data of the refresh are discarded")
+				snapshotCache.Refreshing = false
+				snapshotCache.Stats = snapshot.Stats
+			} else {
+				snapshotCache = snapshot
+				readyChanOrNil = readyChan
+			}
+			close(refreshDone)
 
-		var parsed any
-		if err = json.Unmarshal(data, &parsed); err != nil {
-			log.WithError(err).Error("Fail to parse fetched response")
-			return
+			if queued {
+				queued = false
+				startRefresh(limitedconcurrent.Background)
+			}
 		}
-		cleanedRepository[newKey] = parsed
 	}
-
-	repositoryChan <- cleanedRepository
 }
 
-func githubApiGetRequest(log logrus.FieldLogger, callUrl string, authorizationHeader string) []byte {
-	request, err := http.NewRequest(http.MethodGet, callUrl, nil)
-	if err != nil {
-		log.WithError(err).Error("Fail to create api request")
-		return nil
+func progressFields(progress limitedconcurrent.Progress) logrus.Fields {
+	return logrus.Fields{
+		"total": progress.Total, "started": progress.Started, "completed": progress.Completed, "failed": progress.Failed,
+		"elapsed": progress.Elapsed, "mean_task_duration": progress.MeanTaskDuration(), "max_task_duration": progress.MaxTaskDuration,
 	}
-	request.Header.Set("Accept", "application/vnd.github+json")
-	request.Header.Set("Authorization", authorizationHeader)
-	request.Header.Set("X-GitHub-Api-Version", "2022-11-28")
-
-	response, err := http.DefaultClient.Do(request)
-	if err != nil {
-		log.WithError(err).Error("Fail during api request")
-		return nil
+}
+
+func makeSet(values []string) map[string]empty {
+	set := make(map[string]empty, len(values))
+	for _, value := range values {
+		set[value] = marker
 	}
-	defer response.Body.Close()
+	return set
+}
 
-	data, err := io.ReadAll(response.Body)
-	if err != nil {
-		log.WithError(err).Error("Fail to read api response")
-		return nil
+// stop the timer and drain its channel, so it can be safely reset
+func stopTimer(timer *time.Timer) {
+	if !timer.Stop() {
+		select {
+		case <-timer.C:
+		default:
+		}
 	}
-	return data
 }
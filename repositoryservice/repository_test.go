@@ -0,0 +1,271 @@
+package repositoryservice
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dvaumoron/sclng-backend-test-v1/githubfake"
+	"github.com/sirupsen/logrus"
+)
+
+// refuse calls outside of the fake server, a missed url replacement must not reach the real api
+type fakeOnlyTransport struct {
+	base http.RoundTripper
+	host string
+}
+
+func (t fakeOnlyTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	if request.URL.Host != t.host {
+		return nil, &url.Error{Op: request.Method, URL: request.URL.String(), Err: io.EOF}
+	}
+	return t.base.RoundTrip(request)
+}
+
+// start a fake api and a service without authentication reading its global event feed,
+// the first refresh starts immediately and the next one is an hour later
+func startService(t *testing.T, fakeOptions githubfake.Options, quota int, maxPage int) (RepositoryService, *githubfake.Server) {
+	t.Helper()
+	return startConfiguredService(t, fakeOptions, quota, maxPage, nil)
+}
+
+// configure (when not nil) can change the options of the service before its start
+func startConfiguredService(t *testing.T, fakeOptions githubfake.Options, quota int, maxPage int, configure func(*Options)) (RepositoryService, *githubfake.Server) {
+	t.Helper()
+	if fakeOptions.RateLimit == 0 {
+		fakeOptions.RateLimit = 100000
+	}
+	fake := githubfake.New(fakeOptions)
+	return serveAndMake(t, fake, "/events", quota, maxPage, configure), fake
+}
+
+// the source is the event feed at sourcePath on the server of api
+func serveAndMake(t *testing.T, api http.Handler, sourcePath string, quota int, maxPage int, configure func(*Options)) RepositoryService {
+	t.Helper()
+	server := httptest.NewServer(api)
+	t.Cleanup(server.Close)
+
+	serverUrl, _ := url.Parse(server.URL)
+	httpClient := server.Client()
+	httpClient.Transport = fakeOnlyTransport{base: httpClient.Transport, host: serverUrl.Host}
+
+	auth, err := MakeAuthentication(NoAuth, nil, "", "", nil)
+	if err != nil {
+		t.Fatalf("unexpected error : %v", err)
+	}
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	options := Options{
+		Forge:           GithubForge,
+		Sources:         ParseEventSources([]string{server.URL + sourcePath}, nil, quota),
+		EventPageSize:   10,
+		MaxPage:         maxPage,
+		Backend:         RestBackend,
+		ApiUrl:          server.URL,
+		Strategy:        FixedRefresh(time.Hour),
+		OverlapPolicy:   SkipOverlap,
+		RefreshDeadline: 10 * time.Second,
+		MaxCall:         10,
+		Auth:            auth,
+		HttpClient:      httpClient,
+		UserAgent:       "test",
+	}
+	if configure != nil {
+		configure(&options)
+	}
+	return Make(log, options)
+}
+
+func indexByName(t *testing.T, repositories []JsonObject) map[string]JsonObject {
+	t.Helper()
+	byName := make(map[string]JsonObject, len(repositories))
+	for _, repository := range repositories {
+		fullName, _ := repository["full_name"].(string)
+		if _, duplicate := byName[fullName]; duplicate {
+			t.Fatalf("repository %q returned twice", fullName)
+		}
+		byName[fullName] = repository
+	}
+	return byName
+}
+
+func TestListFollowsEventPages(t *testing.T) {
+	service, _ := startService(t, githubfake.Options{RepositoryCount: 40, EventCount: 100}, 40, 10)
+
+	snapshot := service.Snapshot()
+	if snapshot.Len() != 40 || snapshot.EventPageErrorCount != 0 || snapshot.RepositoryErrorCount != 0 {
+		t.Fatalf("expected 40 repositories without error, got %d (page errors %d, repository errors %d)", snapshot.Len(), snapshot.EventPageErrorCount, snapshot.RepositoryErrorCount)
+	}
+	if reasons := snapshot.ShortfallReasons(); reasons != nil {
+		t.Errorf("expected the quota to be reached, got %v", reasons)
+	}
+
+	// 4 pages of 10 events, one by repository
+	byName := indexByName(t, service.List())
+	repository := byName["owner3/repo8"]
+	if repository == nil {
+		t.Fatalf("expected owner3/repo8 in %v", byName)
+	}
+	if repository["owner"] != "owner3" || repository["watchers_count"] != float64(24) || repository["organization"] != "owner3" {
+		t.Errorf("unexpected cleaned repository : %v", repository)
+	}
+	languages, _ := repository["languages"].(JsonObject)
+	if len(languages) != 1 || languages["Go"] != float64(1008) {
+		t.Errorf("unexpected languages : %v", languages)
+	}
+	events, _ := repository["events"].(JsonObject)
+	if events["count"] != float64(1) {
+		t.Errorf("expected a single event, got %v", events)
+	}
+	if source, _ := repository["source"].([]any); len(source) != 1 {
+		t.Errorf("expected a single source, got %v", repository["source"])
+	}
+}
+
+func TestListStopsAtMaxPage(t *testing.T) {
+	service, _ := startService(t, githubfake.Options{RepositoryCount: 40, EventCount: 100}, 40, 2)
+
+	snapshot := service.Snapshot()
+	if snapshot.Len() != 20 {
+		t.Errorf("expected 20 repositories, got %d", snapshot.Len())
+	}
+	reasons := snapshot.ShortfallReasons()
+	if len(reasons) != 1 || !strings.Contains(reasons[snapshot.Sources()[0]], "maximum number of event pages") {
+		t.Errorf("unexpected shortfall reasons : %v", reasons)
+	}
+}
+
+func TestListCountsRepositoryErrors(t *testing.T) {
+	fakeOptions := githubfake.Options{
+		RepositoryCount: 40, EventCount: 100,
+		Errors: []githubfake.ErrorRule{{PathPrefix: "/repos/owner1/", Status: http.StatusInternalServerError}},
+	}
+	service, _ := startService(t, fakeOptions, 40, 10)
+
+	snapshot := service.Snapshot()
+	if snapshot.Len() != 32 || snapshot.RepositoryErrorCount != 8 {
+		t.Fatalf("expected 32 repositories and 8 errors, got %d and %d", snapshot.Len(), snapshot.RepositoryErrorCount)
+	}
+	for _, repository := range snapshot.Repositories() {
+		if repository["owner"] == "owner1" {
+			t.Errorf("failing repository returned : %v", repository)
+		}
+	}
+}
+
+func TestListKeepsAnonymousCredential(t *testing.T) {
+	fakeOptions := githubfake.Options{
+		RepositoryCount: 40, EventCount: 100,
+		Errors: []githubfake.ErrorRule{{PathPrefix: "/repos/owner1/repo6", Status: http.StatusUnauthorized}},
+	}
+	service, _ := startService(t, fakeOptions, 40, 10)
+
+	// a 401 is a failed call, the next ones (and the next refresh) still work
+	for refreshId := uint64(1); refreshId <= 2; refreshId++ {
+		snapshot := service.Snapshot()
+		if snapshot.RefreshId != refreshId || snapshot.Len() != 39 || snapshot.RepositoryErrorCount != 1 || snapshot.EventPageErrorCount != 0 {
+			t.Fatalf("refresh %d : expected 39 repositories and 1 error, got %d repositories, %d repository errors and %d page errors",
+				snapshot.RefreshId, snapshot.Len(), snapshot.RepositoryErrorCount, snapshot.EventPageErrorCount)
+		}
+		<-service.Refresh().Done
+	}
+}
+
+func TestListCountsEventPageErrors(t *testing.T) {
+	fakeOptions := githubfake.Options{
+		RepositoryCount: 40, EventCount: 100,
+		Errors: []githubfake.ErrorRule{{PathPrefix: "/events", Status: http.StatusBadGateway, Every: 2}},
+	}
+	service, _ := startService(t, fakeOptions, 40, 10)
+
+	// the second page fails, the retrieval of the source stops there
+	snapshot := service.Snapshot()
+	if snapshot.Len() != 10 || snapshot.EventPageErrorCount != 1 {
+		t.Errorf("expected 10 repositories and 1 page error, got %d and %d", snapshot.Len(), snapshot.EventPageErrorCount)
+	}
+	reasons := snapshot.ShortfallReasons()
+	if len(reasons) != 1 || reasons[snapshot.Sources()[0]] != "event page retrieval failed" {
+		t.Errorf("unexpected shortfall reasons : %v", reasons)
+	}
+}
+
+func TestListReplaysFixtures(t *testing.T) {
+	events := []githubfake.JsonObject{
+		{"id": "f1", "type": "PushEvent", "actor": githubfake.JsonObject{"login": "recorded"}, "created_at": "2024-01-02T03:04:05Z",
+			"repo": githubfake.JsonObject{"name": "recorded/project", "url": "https://api.github.com/repos/recorded/project"}},
+		{"id": "f2", "type": "WatchEvent", "actor": githubfake.JsonObject{"login": "recorded"}, "created_at": "2024-01-02T03:00:00Z",
+			"repo": githubfake.JsonObject{"name": "owner0/repo0", "url": "https://api.github.com/repos/owner0/repo0"}},
+	}
+	fixtures := []githubfake.Fixture{
+		fixture(t, "/events?per_page=10", events, map[string]string{"Link": `<https://api.github.com/events?per_page=10&page=2>; rel="next"`}),
+		fixture(t, "/repos/recorded/project", githubfake.JsonObject{
+			"full_name": "recorded/project", "name": "project", "owner": githubfake.JsonObject{"login": "recorded"}, "topics": []string{"replayed"},
+		}, nil),
+		fixture(t, "/repos/recorded/project/languages", githubfake.JsonObject{"Rust": 42}, nil),
+	}
+	service, _ := startService(t, githubfake.Options{RepositoryCount: 40, EventCount: 100, Fixtures: fixtures}, 12, 10)
+
+	// 2 repositories from the recorded page, then 10 from the generated second page (repo10 to repo19)
+	byName := indexByName(t, service.List())
+	if len(byName) != 12 {
+		t.Fatalf("expected 12 repositories, got %d", len(byName))
+	}
+	recorded := byName["recorded/project"]
+	if recorded == nil || recorded["owner"] != "recorded" {
+		t.Fatalf("expected the recorded repository, got %v", recorded)
+	}
+	if languages, _ := recorded["languages"].(JsonObject); languages["Rust"] != float64(42) {
+		t.Errorf("unexpected languages : %v", recorded["languages"])
+	}
+	if events, _ := recorded["events"].(JsonObject); events["last_actor"] != "recorded" || events["last_at"] != "2024-01-02T03:04:05Z" {
+		t.Errorf("unexpected events : %v", recorded["events"])
+	}
+	for _, fullName := range []string{"owner0/repo0", "owner0/repo10", "owner4/repo19"} {
+		if byName[fullName] == nil {
+			t.Errorf("expected %s in the repositories", fullName)
+		}
+	}
+}
+
+func fixture(t *testing.T, uri string, body any, header map[string]string) githubfake.Fixture {
+	t.Helper()
+	data, err := json.Marshal(body)
+	if err != nil {
+		t.Fatalf("unexpected error : %v", err)
+	}
+	return githubfake.Fixture{Method: http.MethodGet, Uri: uri, Status: http.StatusOK, Header: header, Body: data}
+}
+
+func TestIssuesEnricherClampsCount(t *testing.T) {
+	// 3 open pull requests by repository, open_issues_count of the fake is the repository index modulo 7
+	fake := githubfake.New(githubfake.Options{RepositoryCount: 10, EventCount: 10, RateLimit: 100000})
+	api := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/pulls") {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`[{}, {}, {}]`))
+			return
+		}
+		fake.ServeHTTP(w, r)
+	})
+	service := serveAndMake(t, api, "/events", 10, 1, func(options *Options) {
+		options.Enrichers, _ = MakeEnrichers([]string{IssuesEnricher}, nil)
+	})
+
+	snapshot := service.Snapshot()
+	if snapshot.Len() != 10 || snapshot.EnrichmentErrorCount != 0 || snapshot.EnrichmentSkipCount != 0 {
+		t.Fatalf("expected 10 enriched repositories, got %d (enrichment errors %d, skipped %d)", snapshot.Len(), snapshot.EnrichmentErrorCount, snapshot.EnrichmentSkipCount)
+	}
+	byName := indexByName(t, service.List())
+	expected := map[string]float64{"owner0/repo0": 0, "owner2/repo2": 0, "owner3/repo3": 0, "owner4/repo4": 1, "owner1/repo6": 3}
+	for fullName, issueCount := range expected {
+		openCounts, _ := byName[fullName]["open_counts"].(JsonObject)
+		if openCounts["issues"] != issueCount || openCounts["pull_requests"] != float64(3) {
+			t.Errorf("%s : expected %v issues and 3 pull requests, got %v", fullName, issueCount, openCounts)
+		}
+	}
+}
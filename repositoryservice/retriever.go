@@ -0,0 +1,276 @@
+package repositoryservice
+
+import (
+	"context"
+	"time"
+
+	"github.com/dvaumoron/sclng-backend-test-v1/limitedconcurrent"
+	"github.com/sirupsen/logrus"
+)
+
+const partialDelay = time.Second
+
+type retriever struct {
+	log              logrus.FieldLogger
+	backend          string
+	graphqlUrl       string
+	graphqlBatchSize int
+	sources          []EventSource
+	includedTypes    map[string]empty // all types are included when empty
+	excludedTypes    map[string]empty
+	eventPageSize    int
+	maxPage          int
+	pool             limitedconcurrent.Pool // shared by all refreshes
+	client           apiClient
+	forge            Forge
+	apiUrl           string
+	enrichers        []Enricher
+	enricherCache    *enricherCache
+	minRateRemaining int // enrichers are skipped under this remaining rate limit
+}
+
+// sources (by name) where a repository was seen and the events which triggered it
+type repositoryActivity struct {
+	ref        RepositoryRef
+	sources    []string
+	eventCount int
+	types      map[string]*typeActivity
+	lastActor  string
+	lastAt     time.Time
+}
+
+type typeActivity struct {
+	count  int
+	lastAt time.Time
+}
+
+// publish receive partial snapshots while repositories are retrieved (it can be nil)
+func (r retriever) retrieveSnapshot(ctx context.Context, publish func(Snapshot)) Snapshot {
+	start := time.Now()
+	sourceNames := make([]string, 0, len(r.sources))
+	for _, source := range r.sources {
+		sourceNames = append(sourceNames, source.Name)
+	}
+
+	activities, eventPageErrorCount, shortfallReasons := r.collectRepositoriesUrl(ctx)
+	var publishRepositories func([]JsonObject)
+	if publish != nil {
+		publishRepositories = func(repositories []JsonObject) {
+			// copies, because enrichers will modify the repositories
+			copied := make([]JsonObject, 0, len(repositories))
+			for _, repository := range repositories {
+				copied = append(copied, copyObject(repository))
+			}
+			now := time.Now()
+			publish(Snapshot{
+				repositories: copied, FetchedAt: now, RefreshDuration: now.Sub(start), Refreshing: true, Incomplete: true,
+				EventPageErrorCount: eventPageErrorCount, shortfallReasons: shortfallReasons, sources: sourceNames,
+			})
+		}
+	}
+	repositories := r.retrieveRepositoriesData(ctx, activities, publishRepositories)
+	enrichmentErrorCount, enrichmentSkippedCount := r.enrichRepositories(ctx, repositories)
+	end := time.Now()
+
+	return Snapshot{
+		repositories:         repositories,
+		FetchedAt:            end,
+		RefreshDuration:      end.Sub(start),
+		EventPageErrorCount:  eventPageErrorCount,
+		RepositoryErrorCount: len(activities) - len(repositories), // failing tasks does not send any value
+		EnrichmentErrorCount: enrichmentErrorCount,
+		EnrichmentSkipCount:  enrichmentSkippedCount,
+		shortfallReasons:     shortfallReasons,
+		sources:              sourceNames,
+	}
+}
+
+// collect repositories url from all sources, the returned map of reasons contains
+// an entry for each source which did not reach its quota
+func (r retriever) collectRepositoriesUrl(ctx context.Context) (map[string]*repositoryActivity, int, map[string]string) {
+	eventPageErrorCount := 0
+	activities := map[string]*repositoryActivity{}
+	seenEvents := map[string]empty{} // the same event can be in several sources
+	shortfallReasons := map[string]string{}
+	for _, source := range r.sources {
+		pageErrorCount, reason := r.collectSourceRepositoriesUrl(ctx, activities, seenEvents, source)
+		eventPageErrorCount += pageErrorCount
+		if reason != "" {
+			shortfallReasons[source.Name] = reason
+		}
+	}
+	return activities, eventPageErrorCount, shortfallReasons
+}
+
+// follow event pages until the quota of repositories is reached,
+// the returned reason is empty when the quota is reached
+func (r retriever) collectSourceRepositoriesUrl(ctx context.Context, activities map[string]*repositoryActivity, seenEvents map[string]empty, source EventSource) (int, string) {
+	sourceUrls := make(map[string]empty, source.Quota)
+	pageUrl := r.forge.FirstPageUrl(source.Url, r.eventPageSize)
+	for page := 1; len(sourceUrls) < source.Quota; page++ {
+		if ctx.Err() != nil {
+			return 0, "refresh deadline exceeded"
+		}
+		if page > r.maxPage {
+			return 0, "maximum number of event pages reached"
+		}
+
+		nextUrl, eventCount, ok := r.extractRepositoriesUrl(ctx, activities, seenEvents, sourceUrls, source, pageUrl)
+		switch {
+		case !ok:
+			return 1, "event page retrieval failed"
+		case eventCount == 0:
+			return 0, "empty event page"
+		case nextUrl == "" && len(sourceUrls) < source.Quota:
+			return 0, "no more event page"
+		}
+		pageUrl = nextUrl
+	}
+	return 0, ""
+}
+
+// publish (when not nil) is called with the repositories already retrieved, at most every partialDelay
+func (r retriever) retrieveRepositoriesData(ctx context.Context, activities map[string]*repositoryActivity, publish func([]JsonObject)) []JsonObject {
+	if r.backend == GraphqlBackend {
+		return r.retrieveRepositoriesGraphql(ctx, activities)
+	}
+
+	// prepare necessary API calls
+	tasks := make([]func() (JsonObject, error), 0, len(activities))
+	for _, activity := range activities {
+		activityCopy := activity // avoid closure capture
+		tasks = append(tasks, limitedconcurrent.FromSender(func(repositoryChan chan<- JsonObject) {
+			if ctx.Err() == nil { // deadline not exceeded
+				r.retrieveRepositoryData(ctx, repositoryChan, activityCopy)
+			}
+		}))
+	}
+
+	// launch calls on the shared pool (limited parallelism)
+	repositories := make([]JsonObject, 0, len(tasks))
+	lastPublish := time.Now()
+	for result := range limitedconcurrent.SubmitAll(ctx, r.pool, tasks) {
+		if result.Present { // failing tasks are already logged
+			repositories = append(repositories, result.Value)
+		}
+		if publish != nil && time.Since(lastPublish) >= partialDelay {
+			publish(repositories)
+			lastPublish = time.Now()
+		}
+	}
+	return repositories
+}
+
+// return the url of the next page and the number of activities in the page (including the filtered ones)
+func (r retriever) extractRepositoriesUrl(ctx context.Context, activities map[string]*repositoryActivity, seenEvents map[string]empty, sourceUrls map[string]empty, source EventSource, pageUrl string) (string, int, bool) {
+	pageActivities, nextUrl, ok := r.forge.ListActivity(ctx, pageUrl)
+	if !ok {
+		return "", 0, false
+	}
+
+	for _, pageActivity := range pageActivities {
+		if !r.keepEventType(pageActivity.Type) {
+			continue
+		}
+
+		repoUrl := pageActivity.Repository.Url
+		if repoUrl == "" {
+			continue
+		}
+
+		if _, seen := sourceUrls[repoUrl]; !seen {
+			if len(sourceUrls) >= source.Quota {
+				continue // quota reached
+			}
+			sourceUrls[repoUrl] = marker
+
+			activity := activities[repoUrl]
+			if activity == nil {
+				activity = &repositoryActivity{ref: pageActivity.Repository, types: map[string]*typeActivity{}}
+				activities[repoUrl] = activity
+			}
+			activity.sources = append(activity.sources, source.Name)
+		}
+
+		if pageActivity.Id != "" {
+			if _, seen := seenEvents[pageActivity.Id]; seen {
+				continue
+			}
+			seenEvents[pageActivity.Id] = marker
+		}
+		activities[repoUrl].record(pageActivity)
+	}
+	return nextUrl, len(pageActivities), true
+}
+
+func (r retriever) keepEventType(eventType string) bool {
+	if _, excluded := r.excludedTypes[eventType]; excluded {
+		return false
+	}
+	if len(r.includedTypes) == 0 {
+		return true
+	}
+	_, included := r.includedTypes[eventType]
+	return included
+}
+
+func (a *repositoryActivity) record(activity Activity) {
+	a.eventCount++
+	typeInfo := a.types[activity.Type]
+	if typeInfo == nil {
+		typeInfo = &typeActivity{}
+		a.types[activity.Type] = typeInfo
+	}
+	typeInfo.count++
+	if activity.CreatedAt.After(typeInfo.lastAt) {
+		typeInfo.lastAt = activity.CreatedAt
+	}
+
+	if activity.CreatedAt.After(a.lastAt) || a.lastActor == "" {
+		if activity.Actor != "" {
+			a.lastActor = activity.Actor
+		}
+		a.lastAt = activity.CreatedAt
+	}
+}
+
+// []any and JsonObject rather than typed values to behave like the other json values in filters
+func (a *repositoryActivity) toJson() (any, JsonObject) {
+	sources := make([]any, 0, len(a.sources))
+	for _, sourceName := range a.sources {
+		sources = append(sources, sourceName)
+	}
+
+	types := make(JsonObject, len(a.types))
+	for eventType, typeInfo := range a.types {
+		types[eventType] = JsonObject{"count": float64(typeInfo.count), "last_at": formatTime(typeInfo.lastAt)}
+	}
+	events := JsonObject{
+		"count": float64(a.eventCount), "types": types, "last_actor": a.lastActor, "last_at": formatTime(a.lastAt),
+	}
+	return sources, events
+}
+
+func formatTime(t time.Time) any {
+	if t.IsZero() {
+		return nil
+	}
+	return t.Format(time.RFC3339)
+}
+
+func (r retriever) retrieveRepositoryData(ctx context.Context, repositoryChan chan<- JsonObject, activity *repositoryActivity) {
+	cleanedRepository, ok := r.forge.FetchRepository(ctx, activity.ref)
+	if !ok {
+		return
+	}
+
+	languages, ok := r.forge.FetchLanguages(ctx, activity.ref)
+	if !ok {
+		return
+	}
+	cleanedRepository["languages"] = languages
+
+	cleanedRepository["source"], cleanedRepository["events"] = activity.toJson()
+
+	repositoryChan <- cleanedRepository
+}
@@ -0,0 +1,119 @@
+package repositoryservice
+
+import "time"
+
+// repositories are never modified once the snapshot is built,
+// so a snapshot can be shared between goroutines (accessors return copies)
+type Snapshot struct {
+	repositories         []JsonObject
+	RefreshId            uint64 // zero until the first retrieval is done
+	FetchedAt            time.Time
+	RefreshDuration      time.Duration
+	Refreshing           bool
+	EventPageErrorCount  int
+	RepositoryErrorCount int
+	EnrichmentErrorCount int
+	EnrichmentSkipCount  int               // skipped to preserve the rate limit
+	DeadlineExceeded     bool              // repositories are partial
+	Incomplete           bool              // published while the first retrieval is still running
+	shortfallReasons     map[string]string // by source name, why fewer repositories than its quota were collected
+	sources              []string
+	Stats                RefreshStats
+}
+
+// counters since the service start
+type RefreshStats struct {
+	SkippedOverruns  int
+	QueuedOverruns   int
+	DeadlineExceeded int
+}
+
+func (s Snapshot) Ready() bool {
+	return !s.FetchedAt.IsZero()
+}
+
+func (s Snapshot) Len() int {
+	return len(s.repositories)
+}
+
+// return a copy of the names of the sources
+func (s Snapshot) Sources() []string {
+	return append([]string(nil), s.sources...)
+}
+
+// return a copy, nil when every source reached its quota
+func (s Snapshot) ShortfallReasons() map[string]string {
+	if len(s.shortfallReasons) == 0 {
+		return nil
+	}
+	reasons := make(map[string]string, len(s.shortfallReasons))
+	for name, reason := range s.shortfallReasons {
+		reasons[name] = reason
+	}
+	return reasons
+}
+
+// return a deep copy of all repositories
+func (s Snapshot) Repositories() []JsonObject {
+	return s.Filter(nil)
+}
+
+// predicate is applied on shared data (it must not modify its argument), only the matching repositories are copied,
+// a nil predicate match all repositories
+func (s Snapshot) Filter(predicate func(any) bool) []JsonObject {
+	filtered := make([]JsonObject, 0, len(s.repositories))
+	for _, repository := range s.repositories {
+		if predicate == nil || predicate(repository) {
+			filtered = append(filtered, copyObject(repository))
+		}
+	}
+	return filtered
+}
+
+// return a deep copy of the first repositories
+func (s Snapshot) Sample(size int) []JsonObject {
+	if size > len(s.repositories) {
+		size = len(s.repositories)
+	}
+	sample := make([]JsonObject, 0, size)
+	for _, repository := range s.repositories[:size] {
+		sample = append(sample, copyObject(repository))
+	}
+	return sample
+}
+
+// fields of all repositories, with their first non nil value as example (copied)
+func (s Snapshot) Schema() JsonObject {
+	schema := JsonObject{}
+	for _, repository := range s.repositories {
+		for key, value := range repository {
+			if example, ok := schema[key]; !ok || example == nil {
+				schema[key] = value
+			}
+		}
+	}
+	return copyObject(schema)
+}
+
+func copyObject(object JsonObject) JsonObject {
+	copied := make(JsonObject, len(object))
+	for key, value := range object {
+		copied[key] = copyValue(value)
+	}
+	return copied
+}
+
+// scalar values from encoding/json (string, float64, bool and nil) are immutable and can be shared
+func copyValue(value any) any {
+	switch casted := value.(type) {
+	case JsonObject:
+		return copyObject(casted)
+	case []any:
+		copied := make([]any, len(casted))
+		for index, elem := range casted {
+			copied[index] = copyValue(elem)
+		}
+		return copied
+	}
+	return value
+}
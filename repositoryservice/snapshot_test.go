@@ -0,0 +1,99 @@
+package repositoryservice
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dvaumoron/sclng-backend-test-v1/githubfake"
+)
+
+// readers modify everything they receive while refreshes replace the cache,
+// run with -race to check that nothing returned is shared with the cache
+func TestReadersCanNotAlterCache(t *testing.T) {
+	// 2 pages, so the source has a shortfall reason
+	service, _ := startService(t, githubfake.Options{RepositoryCount: 40, EventCount: 100}, 40, 2)
+	expected := indexByName(t, service.List())
+	if len(expected) != 20 {
+		t.Fatalf("expected 20 repositories, got %d", len(expected))
+	}
+
+	done := make(chan empty)
+	var wg sync.WaitGroup
+	for index := 0; index < 2; index++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+
+				snapshot := service.Snapshot()
+				alterRepositories(snapshot.Filter(func(any) bool { return true }))
+				alterRepositories(service.List())
+				alterRepositories(snapshot.Sample(5))
+				for key := range snapshot.Schema() {
+					delete(snapshot.Schema(), key)
+				}
+				if sources := snapshot.Sources(); len(sources) != 0 {
+					sources[0] = "altered"
+				}
+				for name := range snapshot.ShortfallReasons() {
+					delete(snapshot.ShortfallReasons(), name)
+				}
+				time.Sleep(10 * time.Millisecond) // leave time to the refreshes on a single cpu
+			}
+		}()
+	}
+
+	for index := 0; index < 5; index++ {
+		<-service.Refresh().Done
+	}
+	close(done)
+	wg.Wait()
+
+	snapshot := service.Snapshot()
+	if snapshot.RefreshId != 6 {
+		t.Errorf("expected the snapshot of the sixth refresh, got %d", snapshot.RefreshId)
+	}
+	if sources := snapshot.Sources(); len(sources) != 1 || sources[0] == "altered" {
+		t.Errorf("unexpected sources : %v", sources)
+	}
+	if reasons := snapshot.ShortfallReasons(); len(reasons) != 1 {
+		t.Errorf("unexpected shortfall reasons : %v", reasons)
+	}
+	// the fake api returns the same data at each refresh
+	actual := indexByName(t, service.List())
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("cache altered, expected %v, got %v", expected, actual)
+	}
+}
+
+// modify fields, nested objects and nested lists
+func alterRepositories(repositories []JsonObject) {
+	for _, repository := range repositories {
+		repository["full_name"] = "altered"
+		delete(repository, "description")
+		if languages, ok := repository["languages"].(JsonObject); ok {
+			languages["Go"] = float64(-1)
+			languages["Altered"] = float64(1)
+		}
+		if topics, ok := repository["topics"].([]any); ok && len(topics) != 0 {
+			topics[0] = "altered"
+		}
+		if events, ok := repository["events"].(JsonObject); ok {
+			if types, ok := events["types"].(JsonObject); ok {
+				for eventType := range types {
+					delete(types, eventType)
+				}
+			}
+		}
+		if source, ok := repository["source"].([]any); ok && len(source) != 0 {
+			source[0] = "altered"
+		}
+	}
+}
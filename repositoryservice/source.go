@@ -0,0 +1,26 @@
+package repositoryservice
+
+import "strings"
+
+type EventSource struct {
+	Name  string // value used in the "source" field of repositories
+	Url   string // any event API of the forge (global, organization, user or repository feed)
+	Quota int    // maximum number of distinct repositories taken from this source
+}
+
+// spec is "url" or "name=url", quotas are matched by position, the missing ones default to defaultQuota
+func ParseEventSources(specs []string, quotas []int, defaultQuota int) []EventSource {
+	sources := make([]EventSource, 0, len(specs))
+	for index, spec := range specs {
+		source := EventSource{Name: spec, Url: spec, Quota: defaultQuota}
+		// an url can contain "=" in its query, but a name can not contain "/"
+		if name, url, ok := strings.Cut(spec, "="); ok && !strings.Contains(name, "/") {
+			source.Name, source.Url = name, url
+		}
+		if index < len(quotas) {
+			source.Quota = quotas[index]
+		}
+		sources = append(sources, source)
+	}
+	return sources
+}
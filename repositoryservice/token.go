@@ -0,0 +1,100 @@
+package repositoryservice
+
+import (
+	"sync/atomic"
+)
+
+// distribute calls across credentials, each one with its own rate limit
+type tokenPool struct {
+	tokens []*accessToken
+	next   atomic.Uint64 // rotation between tokens with the same remaining quota
+}
+
+type accessToken struct {
+	credential credential
+	rateLimit  *rateLimitState
+	revoked    atomic.Bool // set when the API answer 401 and the credential can not be renewed
+}
+
+func newTokenPool(credentials []credential) *tokenPool {
+	pool := &tokenPool{tokens: make([]*accessToken, 0, len(credentials))}
+	for _, credential := range credentials {
+		pool.tokens = append(pool.tokens, &accessToken{credential: credential, rateLimit: newRateLimitState()})
+	}
+	return pool
+}
+
+// return the valid token with the biggest remaining quota (an unknown quota is preferred),
+// nil when every token is revoked
+func (p *tokenPool) pick() *accessToken {
+	tokenCount := uint64(len(p.tokens))
+	start := p.next.Add(1)
+
+	var picked *accessToken
+	var pickedRemaining int64
+	for i := uint64(0); i < tokenCount; i++ {
+		token := p.tokens[(start+i)%tokenCount]
+		if token.revoked.Load() {
+			continue
+		}
+
+		remaining := token.rateLimit.remaining.Load()
+		if remaining < 0 {
+			return token // not used yet
+		}
+		if picked == nil || remaining > pickedRemaining {
+			picked, pickedRemaining = token, remaining
+		}
+	}
+	return picked
+}
+
+// renew the credential of a refused token when possible, otherwise revoke the token,
+// the booleans indicate if the credential was renewed and if the token was revoked by this call
+func (p *tokenPool) refuse(token *accessToken) (bool, bool) {
+	if token.credential.invalidate() {
+		return true, false
+	}
+	return false, !token.revoked.Swap(true)
+}
+
+// the refusal of an unauthenticated call does not depend on a credential
+func (t *accessToken) anonymous() bool {
+	return t.credential == staticCredential("")
+}
+
+func (p *tokenPool) validCount() int {
+	count := 0
+	for _, token := range p.tokens {
+		if !token.revoked.Load() {
+			count++
+		}
+	}
+	return count
+}
+
+// indicates if the sum of known remaining quotas of valid tokens is under the limit (false when one is unknown)
+func (p *tokenPool) under(limit int) bool {
+	remaining, _ := p.budget()
+	return remaining >= 0 && remaining < int64(limit)
+}
+
+// sum of remaining quotas of valid tokens (-1 when one is unknown) and the latest reset time
+func (p *tokenPool) budget() (int64, int64) {
+	total, latestReset := int64(0), int64(0)
+	for _, token := range p.tokens {
+		if token.revoked.Load() {
+			continue
+		}
+
+		remaining := token.rateLimit.remaining.Load()
+		if remaining < 0 {
+			return -1, 0
+		}
+		total += remaining
+		if reset := token.rateLimit.reset.Load(); reset > latestReset {
+			latestReset = reset
+		}
+	}
+	return total, latestReset
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/Scalingo/go-handlers"
+	"github.com/dvaumoron/sclng-backend-test-v1/limitedconcurrent"
+	"github.com/dvaumoron/sclng-backend-test-v1/repositoryservice"
+)
+
+// progress of the current (or last) refresh and load of the worker pool
+func makeStatusHandler(repoService repositoryservice.RepositoryService) handlers.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, _ map[string]string) error {
+		snapshot, ready := repoService.Peek()
+		result := map[string]any{
+			"ready": ready, "refreshing": snapshot.Refreshing, "published_refresh_id": snapshot.RefreshId,
+			"pool": poolStatsJson(repoService.PoolStats()),
+		}
+		if ready {
+			result["fetched_at"] = snapshot.FetchedAt
+		}
+
+		// the progress is the one of the running refresh, or of the last one
+		if refreshId, progress, ok := repoService.Progress(); ok {
+			result["refresh_id"] = refreshId
+			result["progress"] = map[string]any{
+				"total": progress.Total, "started": progress.Started, "completed": progress.Completed, "failed": progress.Failed,
+				"elapsed": progress.Elapsed.String(), "mean_task_duration": progress.MeanTaskDuration().String(),
+				"max_task_duration": progress.MaxTaskDuration.String(),
+			}
+		}
+
+		writeJson(r, w, http.StatusOK, result)
+		return nil
+	}
+}
+
+func poolStatsJson(stats limitedconcurrent.PoolStats) map[string]int {
+	return map[string]int{"queued": stats.Queued, "running": stats.Running, "completed": stats.Completed, "panicked": stats.Panicked}
+}